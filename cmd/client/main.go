@@ -1,9 +1,13 @@
 package main
 
 import (
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
@@ -29,28 +33,95 @@ func main() {
 		return
 	}
 
-	go exitFromOSSignal()
+	// Moves and wars are signed so nobody on the bus can publish them in this player's name.
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		fmt.Printf("Failed to generate a signing key: %v\n", err)
+		return
+	}
+	// The queue holds on to the directory published for this login until the server's key is pinned to check it.
+	keysQueueName := routing.KeysKey + "." + username
+	keysChannel, _, err := pubsub.DeclareAndBindQueue(connection, routing.ExchangePerilDirect, keysQueueName, routing.KeysKey, pubsub.QueueTypeTransient)
+	if err != nil{
+		fmt.Printf("Failed to subscribe to the key directory: %v\n", err)
+		return
+	}
+	keysChannel.Close()
+
+	// Moves, whispers and team chat only reach the client through private inboxes the server learns about when
+	// it logs in.
 	gameState := gamelogic.NewGameState(username)
-	pauseQueueName := routing.PauseKey + "." + username
-	moveQueueName := routing.ArmyMovesPrefix + "." + username
-	moveQueueKey := routing.ArmyMovesPrefix + ".*"
-	warKey := routing.WarRecognitionsPrefix + ".*"
+	serverKeys := pubsub.NewKeyRing()
+	movesInbox, err := pubsub.SubscribeInboxJSON(connection, serverKeys, signedByServer[gamelogic.ArmyMove], handlerMove(gameState, connection, privateKey))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to move messages: %v\n", err)
+		return
+	}
+	chatInbox, err := pubsub.SubscribeInboxJSON(connection, serverKeys, signedByServer[gamelogic.ChatMessage], handlerChat(gameState))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to whispers: %v\n", err)
+		return
+	}
+
+	credentials, err := gamelogic.ClientCredentials(username, publicKey)
+	if err != nil {
+		fmt.Printf("Error during welcome: %v\n", err)
+		return
+	}
+	inboxes := map[string]string{
+		routing.ArmyMovesPrefix: movesInbox,
+		routing.ChatPrefix:      chatInbox,
+	}
+	credentials.Inboxes = inboxes
+	auth, err := authenticate(connection, credentials)
+	if err != nil {
+		fmt.Printf("Failed to authenticate: %v\n", err)
+		return
+	}
+	if !auth.OK {
+		fmt.Printf("Failed to authenticate: %s\n", auth.Message)
+		return
+	}
+	fmt.Println(auth.Message)
 
-	err = pubsub.SubscribeJSON(connection, routing.ExchangePerilDirect, pauseQueueName, routing.PauseKey, pubsub.QueueTypeTransient, handlerPause(gameState))
+	serverKeys.Replace(map[string][]byte{gamelogic.ServerSigner: auth.ServerKey})
+	keys := pubsub.NewKeyRing()
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, keysQueueName, routing.KeysKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.KeyDirectory], handlerKeys(keys))
 	if err != nil{
-		fmt.Printf("Failed to subscribe to pause messages: %v\n", err)
+		fmt.Printf("Failed to subscribe to the key directory: %v\n", err)
 		return
 	}
-	err = pubsub.SubscribeJSON(connection, routing.ExchangePerilTopic, moveQueueName, moveQueueKey, pubsub.QueueTypeTransient, handlerMove(gameState, connection))
+
+	go exitFromOSSignal()
+	gameState.SetToken(auth.Token)
+
+	lobbyQueueName := routing.LobbyKey + "." + username
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, lobbyQueueName, lobbyQueueName, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.LobbyResponse], handlerLobby(gameState, connection, keys, serverKeys, privateKey))
 	if err != nil{
-		fmt.Printf("Failed to subscribe to move messages: %v\n", err)
+		fmt.Printf("Failed to subscribe to lobby messages: %v\n", err)
 		return
 	}
-	err = pubsub.SubscribeJSON(connection, routing.ExchangePerilTopic, "war", warKey, pubsub.QueueTypeDurable, handlerWar(gameState))
+
+	presenceQueueName := routing.PresencePrefix + "." + username
+	presenceKey := routing.PresencePrefix + ".*"
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, presenceQueueName, presenceKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.PresenceEvent], handlerPresence(gameState))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to presence messages: %v\n", err)
+		return
+	}
+	adminQueueName := routing.AdminPrefix + "." + username
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, adminQueueName, adminQueueName, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.AdminMessage], handlerAdmin(gameState))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to admin messages: %v\n", err)
+		return
+	}
+	announcementsQueueName := routing.AnnouncementsKey + "." + username
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, announcementsQueueName, routing.AnnouncementsKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.AdminMessage], handlerAdmin(gameState))
 	if err != nil{
-		fmt.Printf("Failed to subscribe to war messages: %v\n", err)
+		fmt.Printf("Failed to subscribe to announcements: %v\n", err)
 		return
 	}
+	go sendHeartbeats(gameState, connection)
 
 	channel, err := connection.Channel()
 	if err != nil{
@@ -58,19 +129,87 @@ func main() {
 		return
 	}
 	defer channel.Close()
+
+	announce := gameState.NewLobbyRequest(gamelogic.LobbyActionAnnounce)
+	err = pubsub.PublishJSON(channel, routing.ExchangeDefault, routing.LobbyKey, announce)
+	if err != nil{
+		fmt.Printf("Failed to announce yourself to the lobby: %v\n", err)
+		return
+	}
 	
 	for {
 		commands := gamelogic.GetInput()
 		lenCommands := len(commands)
 		if lenCommands > 0 {
+			if !gameState.SignedIn() && commandNeedsSession(commands[0]){
+				fmt.Println("Your session ended, sign in again: login <password>")
+				continue
+			}
+			if gameState.GetRoom() == "" && commandNeedsRoom(commands[0]){
+				fmt.Println("You must join a room first: join <room>")
+				continue
+			}
 			switch commands[0]{
+				case "join":
+					req, err := gameState.CommandJoin(commands)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					// Nothing is subscribed before the server confirmed it hosts the room.
+					_, err = requestSync(connection, req)
+					if err != nil{
+						fmt.Printf("Failed to join room %s: %v\n", req.Room, err)
+						continue
+					}
+					err = gameState.JoinRoom(req.Room)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					err = subscribeToRoom(connection, gameState, keys, serverKeys, privateKey)
+					if err != nil{
+						fmt.Println(err)
+						return
+					}
+				case "login", "register":
+					if gameState.SignedIn(){
+						fmt.Println("You are already signed in")
+						continue
+					}
+					req, err := gamelogic.ParseCredentials(commands, username, publicKey)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					req.Inboxes = inboxes
+					err = signInAgain(connection, channel, gameState, serverKeys, req)
+					if err != nil{
+						fmt.Println(err)
+					}
+				case "lobby":
+					req, err := gameState.CommandLobby(commands)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					err = pubsub.PublishJSON(channel, routing.ExchangeDefault, routing.LobbyKey, req)
+					if err != nil{
+						fmt.Printf("failed to publish lobby request: %v\n", err)
+					}
 				case "spawn":
 					if lenCommands < 3{
 						fmt.Println("Not enough arguments for spawn command")
 						continue
 					}
-					if gameState.CommandSpawn(commands) != nil{
-						fmt.Println("Failed to spawn unit")
+					err := gameState.CommandSpawn(commands)
+					if err != nil{
+						fmt.Println("Failed to spawn unit:", err)
+						continue
+					}
+					err = publishPlayerState(channel, gameState)
+					if err != nil{
+						fmt.Println(err)
 					}
 				case "move":
 					if lenCommands < 3{
@@ -82,18 +221,112 @@ func main() {
 						fmt.Println("Failed to move unit:", err)
 						continue
 					}
-					err = pubsub.PublishJSON(channel, routing.ExchangePerilTopic, moveQueueKey, moveStruct)
+					err = publishMove(channel, gameState, moveStruct, privateKey)
 					if err != nil{
-						fmt.Printf("failed to publish playing state: %v", err)
+						fmt.Println(err)
 						continue
 					}
+					err = publishPlayerState(channel, gameState)
+					if err != nil{
+						fmt.Println(err)
+					}
+				case "fight", "retreat", "surrender", "reinforce":
+					decision, err := gameState.CommandDecide(commands)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					decisionKey := routing.RoomKey(gameState.GetRoom(), routing.WarDecisionsPrefix, decision.Attacker)
+					err = pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, decisionKey, decision, username, privateKey)
+					if err != nil{
+						fmt.Printf("failed to publish war decision: %v\n", err)
+					}
+				case "combat":
+					err := gameState.CommandCombat(commands)
+					if err != nil{
+						fmt.Println(err)
+					}
 				case "status":
+					if lenCommands > 1 && commands[1] == "enemies"{
+						gameState.CommandStatusEnemies()
+						continue
+					}
 					gameState.CommandStatus()
+				case "map":
+					err := gameState.CommandMap(commands)
+					if err != nil{
+						fmt.Println(err)
+					}
+				case "intel":
+					err := gameState.CommandIntel(commands)
+					if err != nil{
+						fmt.Println(err)
+					}
+				case "say", "whisper":
+					var msg gamelogic.ChatMessage
+					if commands[0] == "say"{
+						msg, err = gameState.CommandSay(commands)
+					} else {
+						msg, err = gameState.CommandWhisper(commands)
+					}
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					err = publishChat(channel, msg, privateKey)
+					if err != nil{
+						fmt.Println(err)
+					}
+				case "team":
+					if lenCommands > 1 && gamelogic.IsTeamAction(commands[1]){
+						req, err := gameState.CommandTeamRequest(commands)
+						if err != nil{
+							fmt.Println(err)
+							continue
+						}
+						resp, err := requestTeam(connection, req)
+						if err != nil{
+							fmt.Println(err)
+							continue
+						}
+						gameState.HandleTeamResponse(resp)
+						continue
+					}
+					msg, err := gameState.CommandTeam(commands)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					err = publishChat(channel, msg, privateKey)
+					if err != nil{
+						fmt.Println(err)
+					}
+				case "diplomacy":
+					msg, ok, err := gameState.CommandDiplomacy(commands)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					if !ok{
+						continue
+					}
+					err = pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, msg.RoutingKey(), msg, username, privateKey)
+					if err != nil{
+						fmt.Printf("failed to publish diplomacy message: %v\n", err)
+					}
 				case "help":
 					gamelogic.PrintClientHelp()
 				case "spam":
 					fmt.Println("Spamming not allowed yet!")
 				case "quit":
+					if gameState.SignedIn(){
+						leave := gameState.Heartbeat()
+						leave.Leaving = true
+						err := sendHeartbeat(connection, leave)
+						if err != nil{
+							fmt.Printf("failed to send leave: %v\n", err)
+						}
+					}
 					gamelogic.PrintQuit()
 					return
 				default:
@@ -104,6 +337,205 @@ func main() {
 	}
 }
 
+// requestTimeout is how long the client waits for the server to answer a request.
+const requestTimeout = 10 * time.Second
+
+// authenticate registers or logs in with the server. The password goes to a queue only the server reads.
+func authenticate(connection *amqp.Connection, req gamelogic.AuthRequest) (gamelogic.AuthResponse, error){
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return pubsub.Request[gamelogic.AuthRequest, gamelogic.AuthResponse](ctx, connection, routing.ExchangeDefault, routing.AuthKey, req)
+}
+
+// signInAgain logs in once the session ended and picks up where the player left off, in the lobby and its room.
+func signInAgain(connection *amqp.Connection, channel *amqp.Channel, gs *gamelogic.GameState, serverKeys *pubsub.KeyRing, req gamelogic.AuthRequest) error{
+	auth, err := authenticate(connection, req)
+	if err != nil{
+		return fmt.Errorf("failed to authenticate: %v", err)
+	}
+	if !auth.OK{
+		return fmt.Errorf("failed to authenticate: %s", auth.Message)
+	}
+	fmt.Println(auth.Message)
+	// a server that restarted signs with a new key
+	serverKeys.Replace(map[string][]byte{gamelogic.ServerSigner: auth.ServerKey})
+	gs.SetToken(auth.Token)
+
+	err = pubsub.PublishJSON(channel, routing.ExchangeDefault, routing.LobbyKey, gs.NewLobbyRequest(gamelogic.LobbyActionAnnounce))
+	if err != nil{
+		return fmt.Errorf("failed to announce yourself to the lobby: %v", err)
+	}
+	if gs.GetRoom() == ""{
+		return nil
+	}
+	return publishPlayerState(channel, gs)
+}
+
+// sendHeartbeats lets the server know the client is still connected, and finds out when its session ended.
+func sendHeartbeats(gs *gamelogic.GameState, connection *amqp.Connection){
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for ; true; <-ticker.C{
+		if !gs.SignedIn(){
+			continue
+		}
+		hb := gs.Heartbeat()
+		err := sendHeartbeat(connection, hb)
+		var remoteErr *pubsub.RemoteError
+		if errors.As(err, &remoteErr){
+			if gs.HandleSessionEnded(hb.Token, remoteErr.Message){
+				fmt.Print("> ")
+			}
+			continue
+		}
+		if err != nil{
+			fmt.Printf("failed to send heartbeat: %v\n", err)
+		}
+	}
+}
+
+// sendHeartbeat tells the server the client is still there, or that it is leaving.
+func sendHeartbeat(connection *amqp.Connection, hb gamelogic.Heartbeat) error{
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := pubsub.Request[gamelogic.Heartbeat, gamelogic.HeartbeatReply](ctx, connection, routing.ExchangeDefault, routing.HeartbeatsKey, hb)
+	return err
+}
+
+func commandNeedsSession(command string) bool{
+	switch command{
+		case "login", "register", "status", "map", "intel", "combat", "help", "quit":
+			return false
+		default:
+			return true
+	}
+}
+
+func commandNeedsRoom(command string) bool{
+	switch command{
+		case "map", "spawn", "move", "fight", "retreat", "surrender", "reinforce", "say", "whisper", "team", "diplomacy":
+			return true
+		default:
+			return false
+	}
+}
+
+// subscribeToRoom sets up every queue the client needs, all of them scoped to the room it joined.
+func subscribeToRoom(connection *amqp.Connection, gs *gamelogic.GameState, keys, serverKeys *pubsub.KeyRing, privateKey ed25519.PrivateKey) error{
+	room := gs.GetRoom()
+	username := gs.GetUsername()
+	pauseKey := routing.RoomKey(room, routing.PauseKey)
+	pauseQueueName := routing.RoomKey(room, routing.PauseKey, username)
+	warQueueName := routing.RoomKey(room, routing.WarRecognitionsPrefix, username)
+	warResolutionQueueName := routing.RoomKey(room, routing.WarResolutionsPrefix, username)
+	warAckQueueName := routing.RoomKey(room, routing.WarAcksPrefix, username)
+	warDecisionQueueName := routing.RoomKey(room, routing.WarDecisionsPrefix, username)
+	treasuryQueueName := routing.RoomKey(room, routing.TreasuryPrefix, username)
+	supplyQueueName := routing.RoomKey(room, routing.SupplyPrefix, username)
+	gameOverKey := routing.RoomKey(room, routing.GameOverKey)
+	gameOverQueueName := routing.RoomKey(room, routing.GameOverKey, username)
+	controlKey := routing.RoomKey(room, routing.ControlKey)
+	controlQueueName := routing.RoomKey(room, routing.ControlKey, username)
+	chatKey := routing.RoomKey(room, routing.ChatPrefix, string(gamelogic.ChatGlobal))
+	diplomacyQueueName := routing.RoomKey(room, routing.DiplomacyPrefix, username)
+	resetKey := routing.RoomKey(room, routing.ResetKey)
+	resetQueueName := routing.RoomKey(room, routing.ResetKey, username)
+
+	err := pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, pauseQueueName, pauseKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[routing.PlayingState], handlerPause(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to pause messages: %v", err)
+	}
+	// The server pauses a single player on the queue's own name.
+	err = pubsub.BindQueue(connection, routing.ExchangePerilDirect, pauseQueueName, pauseQueueName)
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to player pause messages: %v", err)
+	}
+	// Each player gets its own war queue so recognitions reach the players involved instead of being round-robined.
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, warQueueName, warQueueName, pubsub.QueueTypeDurable, keys, func(row gamelogic.RecognitionOfWar) string { return row.Defender.Username }, handlerWar(gs, connection, privateKey))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to war messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, warResolutionQueueName, warResolutionQueueName, pubsub.QueueTypeDurable, keys, func(res gamelogic.WarResolution) string { return res.Attacker }, handlerWarResolution(gs, connection, privateKey))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to war resolution messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, warAckQueueName, warAckQueueName, pubsub.QueueTypeDurable, keys, func(ack gamelogic.WarAck) string { return ack.Username }, handlerWarAck(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to war ack messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, warDecisionQueueName, warDecisionQueueName, pubsub.QueueTypeDurable, keys, func(d gamelogic.WarDecision) string { return d.Username }, handlerWarDecision(gs, connection, privateKey))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to war decision messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, treasuryQueueName, treasuryQueueName, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.TreasuryUpdate], handlerTreasury(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to treasury messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, supplyQueueName, supplyQueueName, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.SupplyUpdate], handlerSupply(gs, connection))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to supply messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, gameOverQueueName, gameOverKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.GameOver], handlerGameOver(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to game over messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, controlQueueName, controlKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.ControlChange], handlerControl(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to control messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, resetQueueName, resetKey, pubsub.QueueTypeTransient, serverKeys, signedByServer[gamelogic.AdminMessage], handlerAdmin(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to reset messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, chatQueueName(gs), chatKey, pubsub.QueueTypeTransient, keys, func(msg gamelogic.ChatMessage) string { return msg.Username }, handlerChat(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to chat messages: %v", err)
+	}
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, diplomacyQueueName, diplomacyQueueName, pubsub.QueueTypeDurable, keys, func(msg gamelogic.DiplomacyMessage) string { return msg.From }, handlerDiplomacy(gs))
+	if err != nil{
+		return fmt.Errorf("failed to subscribe to diplomacy messages: %v", err)
+	}
+
+	// The subscriptions only see what is published from now on, so ask the server what happened before.
+	snap, err := requestSync(connection, gs.NewSyncRequest())
+	var remoteErr *pubsub.RemoteError
+	if errors.As(err, &remoteErr){
+		fmt.Println(remoteErr)
+		return nil
+	}
+	if err != nil{
+		return fmt.Errorf("failed to sync with room %s: %v", gs.GetRoom(), err)
+	}
+	gs.HandleSync(snap)
+	return nil
+}
+
+// chatQueueName is the queue the room's chat is bound to. Whispers and team chat come in on the chat inbox.
+func chatQueueName(gs *gamelogic.GameState) string{
+	return routing.RoomKey(gs.GetRoom(), routing.ChatPrefix, gs.GetUsername())
+}
+
+// requestSync asks the server for the state of the room the client just joined.
+func requestSync(connection *amqp.Connection, req gamelogic.SyncRequest) (gamelogic.WorldSnapshot, error){
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return pubsub.Request[gamelogic.SyncRequest, gamelogic.WorldSnapshot](ctx, connection, routing.ExchangeDefault, routing.SyncKey, req)
+}
+
+// requestTeam asks the server to put the player on a team, have it invite someone or leave it.
+func requestTeam(connection *amqp.Connection, req gamelogic.TeamRequest) (gamelogic.TeamResponse, error){
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return pubsub.Request[gamelogic.TeamRequest, gamelogic.TeamResponse](ctx, connection, routing.ExchangeDefault, routing.TeamsKey, req)
+}
+
+// requestBattlefield asks the server who has units where an attacker moved in.
+func requestBattlefield(connection *amqp.Connection, req gamelogic.BattlefieldRequest) (gamelogic.Battlefield, error){
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return pubsub.Request[gamelogic.BattlefieldRequest, gamelogic.Battlefield](ctx, connection, routing.ExchangeDefault, routing.BattlefieldKey, req)
+}
+
 func exitFromOSSignal(){
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal, os.Interrupt)
@@ -112,6 +544,37 @@ func exitFromOSSignal(){
 	os.Exit(0)
 }
 
+// publishChat sends a chat message where its channel goes, signed so nobody can speak for someone else.
+func publishChat(channel *amqp.Channel, msg gamelogic.ChatMessage, privateKey ed25519.PrivateKey) error{
+	exchange, key := msg.Route()
+	err := pubsub.PublishSignedJSON(channel, exchange, key, msg, msg.Username, privateKey)
+	if err != nil{
+		return fmt.Errorf("failed to publish chat message: %v", err)
+	}
+	return nil
+}
+
+// publishMove hands a move to the server, which only passes it on to the players that can see it. It is
+// signed so nobody can move someone else's units.
+func publishMove(channel *amqp.Channel, gs *gamelogic.GameState, mv gamelogic.ArmyMove, privateKey ed25519.PrivateKey) error{
+	moveKey := routing.RoomKey(gs.GetRoom(), routing.ArmyMovesPrefix)
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangeDefault, moveKey, mv, gs.GetUsername(), privateKey)
+	if err != nil{
+		return fmt.Errorf("failed to publish move: %v", err)
+	}
+	return nil
+}
+
+// publishPlayerState reports the player's full state to the server only, other players never see it.
+func publishPlayerState(channel *amqp.Channel, gs *gamelogic.GameState) error{
+	key := routing.RoomKey(gs.GetRoom(), routing.PlayerStatesKey)
+	err := pubsub.PublishJSON(channel, routing.ExchangeDefault, key, gs.GetPlayerState())
+	if err != nil{
+		return fmt.Errorf("failed to publish player state: %v", err)
+	}
+	return nil
+}
+
 func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState)(pubsub.AnkType){
 	return func(ps routing.PlayingState)(pubsub.AnkType){
 		defer fmt.Print("> ")
@@ -120,7 +583,7 @@ func handlerPause(gs *gamelogic.GameState) func(routing.PlayingState)(pubsub.Ank
 	}
 }
 
-func handlerMove(gs *gamelogic.GameState, connection *amqp.Connection) func(gamelogic.ArmyMove)(pubsub.AnkType){
+func handlerMove(gs *gamelogic.GameState, connection *amqp.Connection, privateKey ed25519.PrivateKey) func(gamelogic.ArmyMove)(pubsub.AnkType){
 	return func(am gamelogic.ArmyMove)(pubsub.AnkType){
 		defer fmt.Print("> ")
 		moveOutCome := gs.HandleMove(am)
@@ -128,8 +591,6 @@ func handlerMove(gs *gamelogic.GameState, connection *amqp.Connection) func(game
 			case gamelogic.MoveOutComeSafe:
 				return pubsub.Ack
 			case gamelogic.MoveOutcomeMakeWar:
-				warKey := routing.WarRecognitionsPrefix + "." + am.Player.Username
-				
 				channel, err := connection.Channel()
 				if err != nil{
 					fmt.Printf("Failed to open a channel: %v\n", err)
@@ -137,15 +598,30 @@ func handlerMove(gs *gamelogic.GameState, connection *amqp.Connection) func(game
 				}
 				defer channel.Close()
 				
-				warDec := gamelogic.RecognitionOfWar{
-					Attacker: am.Player,
-					Defender: gs.GetPlayerSnap(),
+				// Only the server knows who is really in the battlefield, intel may be stale.
+				battlefield, err := requestBattlefield(connection, gs.NewBattlefieldRequest(am.Player))
+				var remoteErr *pubsub.RemoteError
+				if errors.As(err, &remoteErr){
+					fmt.Println(remoteErr)
+					return pubsub.NackDiscard
 				}
-				err = pubsub.PublishJSON(channel, routing.ExchangePerilTopic, warKey, warDec)
 				if err != nil{
-					fmt.Printf("failed to publish playing state: %v", err)
+					fmt.Printf("Failed to find out who is in the battlefield: %v\n", err)
 					return pubsub.NackRequeue
 				}
+				warDec, ok := gs.NewRecognitionOfWar(am, battlefield)
+				if !ok{
+					return pubsub.Ack
+				}
+				// The attacker resolves the war and everyone else checks its result, so all of them need a copy.
+				for _, username := range warDec.Participants(){
+					warKey := routing.RoomKey(gs.GetRoom(), routing.WarRecognitionsPrefix, username)
+					err = pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, warKey, warDec, gs.GetUsername(), privateKey)
+					if err != nil{
+						fmt.Printf("failed to publish war recognition: %v", err)
+						return pubsub.NackRequeue
+					}
+				}
 				
 				return pubsub.Ack
 			default:
@@ -155,28 +631,275 @@ func handlerMove(gs *gamelogic.GameState, connection *amqp.Connection) func(game
 	}
 }
 
-func handlerWar(gs *gamelogic.GameState) func(gamelogic.RecognitionOfWar)(pubsub.AnkType){
+func handlerWar(gs *gamelogic.GameState, connection *amqp.Connection, privateKey ed25519.PrivateKey) func(gamelogic.RecognitionOfWar)(pubsub.AnkType){
 	return func(row gamelogic.RecognitionOfWar)(pubsub.AnkType){
 		defer fmt.Print("> ")
-		outcome, winner, loser := gs.HandleWar(row)
+		outcome := gs.HandleWar(row)
 		switch outcome{
 			case gamelogic.WarOutcomeNotInvolved:
-				return pubsub.NackRequeue
+				return pubsub.NackDiscard
 			case gamelogic.WarOutcomeNoUnits:
 				return pubsub.NackDiscard
-			case gamelogic.WarOutcomeOpponentWon:
-				fmt.Printf("You lost the war against %s. Better luck next time!\n", winner)
+			case gamelogic.WarOutcomeAwaitingResolution:
+				// the resolution may have overtaken the recognition
+				if res, ok := gs.PopHeldResolution(row.ID); ok{
+					handlerWarResolution(gs, connection, privateKey)(res)
+				}
 				return pubsub.Ack
-			case gamelogic.WarOutcomeYouWon:
-				fmt.Printf("Congratulations! You won the war against %s!\n", loser)
+			case gamelogic.WarOutcomeAwaitingDecisions:
+				go closeWarAfter(gs, connection, privateKey, row)
+				return pubsub.Ack
+			default:
+				fmt.Println("Unknown war outcome")
+				return pubsub.NackDiscard
+		}
+	}
+}
+
+// closeWarAfter fights the war with whatever decisions arrived once the decision window closed,
+// unless the last decision already got it fought.
+func closeWarAfter(gs *gamelogic.GameState, connection *amqp.Connection, privateKey ed25519.PrivateKey, row gamelogic.RecognitionOfWar){
+	time.Sleep(time.Until(row.DecideBy) + gamelogic.WarDecisionGrace)
+	res, ok := gs.CloseWar(row.ID)
+	if !ok{
+		return
+	}
+	defer fmt.Print("> ")
+	err := publishWarResolution(connection, gs, res, privateKey)
+	if err != nil{
+		fmt.Println(err)
+	}
+}
+
+func handlerWarDecision(gs *gamelogic.GameState, connection *amqp.Connection, privateKey ed25519.PrivateKey) func(gamelogic.WarDecision)(pubsub.AnkType){
+	return func(d gamelogic.WarDecision)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		res, ok := gs.HandleWarDecision(d)
+		if !ok{
+			return pubsub.Ack
+		}
+		err := publishWarResolution(connection, gs, res, privateKey)
+		if err != nil{
+			fmt.Println(err)
+		}
+		return pubsub.Ack
+	}
+}
+
+// publishWarResolution sends the attacker's resolution to every participant, the attacker included.
+func publishWarResolution(connection *amqp.Connection, gs *gamelogic.GameState, res gamelogic.WarResolution, privateKey ed25519.PrivateKey) error{
+	channel, err := connection.Channel()
+	if err != nil{
+		return fmt.Errorf("failed to open a channel: %v", err)
+	}
+	defer channel.Close()
+
+	for _, username := range res.Participants(){
+		resolutionKey := routing.RoomKey(gs.GetRoom(), routing.WarResolutionsPrefix, username)
+		err = pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, resolutionKey, res, gs.GetUsername(), privateKey)
+		if err != nil{
+			return fmt.Errorf("failed to publish war resolution: %v", err)
+		}
+	}
+	return nil
+}
+
+func handlerWarResolution(gs *gamelogic.GameState, connection *amqp.Connection, privateKey ed25519.PrivateKey) func(gamelogic.WarResolution)(pubsub.AnkType){
+	return func(res gamelogic.WarResolution)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		outcome, ack, mv := gs.HandleWarResolution(res)
+		switch outcome{
+			case gamelogic.WarOutcomeNotInvolved:
+				return pubsub.NackDiscard
+			case gamelogic.WarOutcomeAwaitingRecognition:
+				go dropUnverifiedResolution(gs, connection, privateKey, res.WarID)
 				return pubsub.Ack
+			case gamelogic.WarOutcomeOpponentWon:
+				fmt.Printf("You lost the war against %s. Better luck next time!\n", res.Winner)
+			case gamelogic.WarOutcomeYouWon:
+				fmt.Printf("Congratulations! You won the war against %v!\n", res.Losers)
 			case gamelogic.WarOutcomeDraw:
 				fmt.Println("The war ended in a draw. No one wins!")
-				return pubsub.Ack
 			default:
 				fmt.Println("Unknown war outcome")
 				return pubsub.NackDiscard
 		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.Ack
+		}
+		defer channel.Close()
+
+		// The casualties are already applied, so a failed ack must not requeue the resolution.
+		publishWarAck(channel, gs, res, ack, privateKey)
+		// Units that retreated or reinforced only move on the server's records through their move.
+		if len(mv.Units) > 0{
+			err = publishMove(channel, gs, mv, privateKey)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		err = publishPlayerState(channel, gs)
+		if err != nil{
+			fmt.Println(err)
+		}
+		return pubsub.Ack
 	}
 }
 
+// publishWarAck tells every other participant whether the player agrees with a resolution.
+func publishWarAck(channel *amqp.Channel, gs *gamelogic.GameState, res gamelogic.WarResolution, ack gamelogic.WarAck, privateKey ed25519.PrivateKey){
+	for _, username := range res.Participants(){
+		if username == ack.Username{
+			continue
+		}
+		ackKey := routing.RoomKey(gs.GetRoom(), routing.WarAcksPrefix, username)
+		err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, ackKey, ack, gs.GetUsername(), privateKey)
+		if err != nil{
+			fmt.Printf("failed to publish war ack: %v\n", err)
+		}
+	}
+}
+
+// dropUnverifiedResolution disputes a resolution whose recognition never arrived, without it nothing can be
+// checked.
+func dropUnverifiedResolution(gs *gamelogic.GameState, connection *amqp.Connection, privateKey ed25519.PrivateKey, warID string){
+	time.Sleep(gamelogic.ResolutionHoldTime)
+	res, ack, ok := gs.DropHeldResolution(warID)
+	if !ok{
+		return
+	}
+	defer fmt.Print("> ")
+
+	channel, err := connection.Channel()
+	if err != nil{
+		fmt.Printf("Failed to open a channel: %v\n", err)
+		return
+	}
+	defer channel.Close()
+	publishWarAck(channel, gs, res, ack, privateKey)
+}
+
+func handlerWarAck(gs *gamelogic.GameState) func(gamelogic.WarAck)(pubsub.AnkType){
+	return func(ack gamelogic.WarAck)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		gs.HandleWarAck(ack)
+		return pubsub.Ack
+	}
+}
+
+func handlerTreasury(gs *gamelogic.GameState) func(gamelogic.TreasuryUpdate)(pubsub.AnkType){
+	return func(tu gamelogic.TreasuryUpdate)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		gs.HandleTreasuryUpdate(tu)
+		return pubsub.Ack
+	}
+}
+
+func handlerSupply(gs *gamelogic.GameState, connection *amqp.Connection) func(gamelogic.SupplyUpdate)(pubsub.AnkType){
+	return func(su gamelogic.SupplyUpdate)(pubsub.AnkType){
+		if !gs.HandleSupplyUpdate(su){
+			return pubsub.Ack
+		}
+		defer fmt.Print("> ")
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.Ack
+		}
+		defer channel.Close()
+
+		// The attrition is already applied, so a failed report must not requeue the update.
+		err = publishPlayerState(channel, gs)
+		if err != nil{
+			fmt.Println(err)
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerControl(gs *gamelogic.GameState) func(gamelogic.ControlChange)(pubsub.AnkType){
+	return func(cc gamelogic.ControlChange)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		gs.HandleControlChange(cc)
+		return pubsub.Ack
+	}
+}
+
+func handlerGameOver(gs *gamelogic.GameState) func(gamelogic.GameOver)(pubsub.AnkType){
+	return func(over gamelogic.GameOver)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		gs.HandleGameOver(over)
+		return pubsub.Ack
+	}
+}
+
+func handlerLobby(gs *gamelogic.GameState, connection *amqp.Connection, keys, serverKeys *pubsub.KeyRing, privateKey ed25519.PrivateKey) func(gamelogic.LobbyResponse)(pubsub.AnkType){
+	return func(resp gamelogic.LobbyResponse)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		if !gs.HandleLobbyResponse(resp){
+			return pubsub.Ack
+		}
+		err := subscribeToRoom(connection, gs, keys, serverKeys, privateKey)
+		if err != nil{
+			fmt.Println(err)
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerPresence(gs *gamelogic.GameState) func(gamelogic.PresenceEvent)(pubsub.AnkType){
+	return func(event gamelogic.PresenceEvent)(pubsub.AnkType){
+		gs.HandlePresence(event)
+		if event.Username != gs.GetUsername(){
+			fmt.Print("> ")
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerKeys(keys *pubsub.KeyRing) func(gamelogic.KeyDirectory)(pubsub.AnkType){
+	return func(dir gamelogic.KeyDirectory)(pubsub.AnkType){
+		keys.Replace(dir.Keys)
+		keys.SetRequired(dir.SigningRequired)
+		return pubsub.Ack
+	}
+}
+
+// signedByServer is what messages only the server may send claim to be signed by.
+func signedByServer[T any](T) string{
+	return gamelogic.ServerSigner
+}
+
+// handlerAdmin acts on kicks, bans, announcements and resets, which are only handed over once verified
+// against the server's key. HandleAdmin ignores any meant for someone else or replayed later, so no player
+// can disconnect or wipe another.
+func handlerAdmin(gs *gamelogic.GameState) func(gamelogic.AdminMessage)(pubsub.AnkType){
+	return func(msg gamelogic.AdminMessage)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		if gs.HandleAdmin(msg){
+			fmt.Println("\nDisconnecting from Peril...")
+			os.Exit(0)
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerChat(gs *gamelogic.GameState) func(gamelogic.ChatMessage)(pubsub.AnkType){
+	return func(msg gamelogic.ChatMessage)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		gs.HandleChat(msg)
+		return pubsub.Ack
+	}
+}
+
+func handlerDiplomacy(gs *gamelogic.GameState) func(gamelogic.DiplomacyMessage)(pubsub.AnkType){
+	return func(msg gamelogic.DiplomacyMessage)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		gs.HandleDiplomacy(msg)
+		return pubsub.Ack
+	}
+}
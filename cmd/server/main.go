@@ -1,9 +1,14 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
@@ -29,14 +34,90 @@ func main() {
 	}
 	defer channel.Close()
 
-	queueName := "game_logs"
+	queueName := routing.GameLogSlug
 	key := queueName + ".*"
-	queueChannel, _, err := pubsub.DeclareAndBindQueue(connection, routing.ExchangePerilTopic,queueName,key,pubsub.QueueTypeDurable)
+	err = pubsub.SubscribeJSON(connection, routing.ExchangePerilTopic, queueName, key, pubsub.QueueTypeDurable, handlerLog())
 	if err != nil{
-		fmt.Printf("Failed to declare and bind queue: %v\n", err)
+		fmt.Printf("Failed to subscribe to game logs: %v\n", err)
+		return
+	}
+
+	accounts, err := gamelogic.NewAccounts()
+	if err != nil{
+		fmt.Println(err)
+		return
+	}
+
+	// The server reads its own key directory to verify the moves and chat players send, and only trusts it
+	// signed by itself.
+	keys := pubsub.NewKeyRing()
+	serverKeys := pubsub.NewKeyRing()
+	serverKeys.Replace(map[string][]byte{gamelogic.ServerSigner: accounts.ServerKey().Public().(ed25519.PublicKey)})
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilDirect, routing.KeysKey, routing.KeysKey, pubsub.QueueTypeTransient, serverKeys, func(gamelogic.KeyDirectory) string { return gamelogic.ServerSigner }, handlerKeys(keys))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to the key directory: %v\n", err)
+		return
+	}
+
+	// Every room is an independent game with its own world, the default one always exists.
+	chatLogging := &atomic.Bool{}
+	rooms := newRoomRegistry(connection, accounts, keys, chatLogging)
+	_, err = rooms.create(routing.DefaultRoom)
+	if err != nil{
+		fmt.Println(err)
+		return
+	}
+
+	// Everything that carries a password or a session token comes in on the default exchange, to queues only
+	// the server reads and nobody can bind to.
+	lobby := gamelogic.NewLobby(func(room string) bool {
+		_, ok := rooms.get(room)
+		return ok
+	})
+	err = pubsub.SubscribeJSON(connection, routing.ExchangeDefault, routing.LobbyKey, "", pubsub.QueueTypeTransient, handlerLobby(lobby, accounts, rooms, connection))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to the lobby: %v\n", err)
+		return
+	}
+
+	presence := gamelogic.NewPresence()
+	err = pubsub.Serve(connection, routing.ExchangeDefault, routing.HeartbeatsKey, "", pubsub.QueueTypeTransient, handlerHeartbeat(presence, accounts, lobby, rooms, connection))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to heartbeats: %v\n", err)
+		return
+	}
+	go sweepPresence(presence, accounts, lobby, rooms, connection)
+
+	err = pubsub.Serve(connection, routing.ExchangeDefault, routing.AuthKey, "", pubsub.QueueTypeTransient, handlerAuth(accounts, presence, connection))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to auth requests: %v\n", err)
+		return
+	}
+
+	err = pubsub.Serve(connection, routing.ExchangeDefault, routing.SyncKey, "", pubsub.QueueTypeTransient, handlerSync(accounts, rooms))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to sync requests: %v\n", err)
+		return
+	}
+
+	err = pubsub.Serve(connection, routing.ExchangeDefault, routing.BattlefieldKey, "", pubsub.QueueTypeTransient, handlerBattlefield(accounts, rooms))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to battlefield requests: %v\n", err)
+		return
+	}
+
+	err = pubsub.Serve(connection, routing.ExchangeDefault, routing.TeamsKey, "", pubsub.QueueTypeTransient, handlerTeam(accounts, rooms))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to team requests: %v\n", err)
+		return
+	}
+
+	// Team chat is logged by the rooms that relay it.
+	err = pubsub.SubscribeVerifiedJSON(connection, routing.ExchangePerilTopic, routing.ChatLogsKey, "*." + routing.ChatPrefix + "." + string(gamelogic.ChatGlobal), pubsub.QueueTypeDurable, keys, func(msg gamelogic.ChatMessage) string { return msg.Username }, handlerChatLog(chatLogging))
+	if err != nil{
+		fmt.Printf("Failed to subscribe to chat: %v\n", err)
 		return
 	}
-	defer queueChannel.Close()
 
 	fmt.Println("Connected to RabbitMQ successfully.")
 	go exitFromOSSignal()
@@ -45,20 +126,145 @@ func main() {
 		commands := gamelogic.GetInput()
 		if len(commands) > 0 {
 			switch commands[0]{
-				case "pause":
-					fmt.Println("Pausing the game...")
-					if publishPlayingState(channel, true) != nil{
-						fmt.Println("Failed to publish pause state")
+				case "create":
+					if len(commands) < 2{
+						fmt.Println("usage: create <room>")
+						continue
+					}
+					_, err := rooms.create(commands[1])
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					fmt.Printf("Room %s created\n", commands[1])
+				case "rooms":
+					for _, world := range rooms.all(){
+						fmt.Printf("* %s: %v player(s), disconnected: %v, paused: %v, victory: %v\n", world.Room(), len(world.Usernames()), world.Disconnected(), world.IsPaused(), world.GetVictory())
+					}
+				case "pause", "resume":
+					cmd, err := gamelogic.ParsePauseCommand(commands)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					err = applyPause(connection, channel, presence, accounts, rooms, cmd)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					if cmd.State.IsPaused{
+						fmt.Println("Game paused")
+					} else {
+						fmt.Println("Game resumed")
+					}
+				case "players", "list":
+					gamelogic.PrintPlayers(presence.Connected())
+				case "kick", "ban":
+					if len(commands) < 2{
+						fmt.Printf("usage: %s <player> [reason]\n", commands[0])
+						continue
+					}
+					username := commands[1]
+					action := gamelogic.AdminAction(gamelogic.AdminActionKick)
+					if commands[0] == "ban"{
+						err := accounts.Ban(username, true)
+						if err != nil{
+							fmt.Println(err)
+							continue
+						}
+						action = gamelogic.AdminActionBan
+					}
+					err := kickPlayer(channel, presence, accounts, lobby, rooms, username, action, strings.Join(commands[2:], " "))
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					fmt.Printf("%s was removed from the server\n", username)
+				case "unban":
+					if len(commands) < 2{
+						fmt.Println("usage: unban <player>")
+						continue
+					}
+					err := accounts.Ban(commands[1], false)
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					fmt.Printf("%s can log in again\n", commands[1])
+				case "broadcast":
+					if len(commands) < 2{
+						fmt.Println("usage: broadcast <message>")
+						continue
+					}
+					announcement := gamelogic.AdminMessage{
+						Action:  gamelogic.AdminActionAnnounce,
+						Message: strings.Join(commands[1:], " "),
+						SentAt:  time.Now(),
+					}
+					err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.AnnouncementsKey, announcement, gamelogic.ServerSigner, accounts.ServerKey())
+					if err != nil{
+						fmt.Printf("failed to publish announcement: %v\n", err)
+						continue
+					}
+					fmt.Println("Announcement sent")
+				case "reset":
+					if len(commands) < 2{
+						fmt.Println("usage: reset <room>")
+						continue
+					}
+					world, ok := rooms.get(commands[1])
+					if !ok{
+						fmt.Printf("Unknown room %s\n", commands[1])
+						continue
+					}
+					world.Reset()
+					reset := gamelogic.AdminMessage{
+						Action: gamelogic.AdminActionReset,
+						Room:   world.Room(),
+						SentAt: time.Now(),
+					}
+					err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.RoomKey(world.Room(), routing.ResetKey), reset, gamelogic.ServerSigner, accounts.ServerKey())
+					if err != nil{
+						fmt.Printf("failed to publish reset: %v\n", err)
+						continue
+					}
+					fmt.Printf("Room %s was reset\n", world.Room())
+				case "victory":
+					if len(commands) < 2{
+						fmt.Println("usage: victory <room> [elimination | control <locations> <turns> | score <minutes>]")
 						continue
 					}
-					fmt.Println("Game paused")
-				case "resume":
-					fmt.Println("Resuming the game...")
-					if publishPlayingState(channel, false) != nil{
-						fmt.Println("Failed to publish pause state")
+					world, ok := rooms.get(commands[1])
+					if !ok{
+						fmt.Printf("Unknown room %s\n", commands[1])
 						continue
 					}
-					fmt.Println("Games resumed")
+					if len(commands) == 2{
+						fmt.Printf("Victory condition: %v\n", world.GetVictory())
+						continue
+					}
+					victory, err := gamelogic.ParseVictoryConfig(commands[1:])
+					if err != nil{
+						fmt.Println(err)
+						continue
+					}
+					world.SetVictory(victory)
+					fmt.Printf("Victory condition set to: %v\n", victory)
+				case "signing":
+					if len(commands) > 1{
+						accounts.SetSigningRequired(commands[1] == "on")
+						err := publishKeyDirectory(channel, accounts)
+						if err != nil{
+							fmt.Println(err)
+							continue
+						}
+					}
+					fmt.Printf("Signatures required: %v\n", accounts.KeyDirectory().SigningRequired)
+				case "chatlog":
+					if len(commands) > 1{
+						chatLogging.Store(commands[1] == "on")
+					}
+					fmt.Printf("Chat logging: %v\n", chatLogging.Load())
 				case "quit":
 					fmt.Println("Quitting the server...")
 					return
@@ -71,17 +277,590 @@ func main() {
 
 }
 
-func publishPlayingState(channel *amqp.Channel, isPaused bool) error{
-	playState := routing.PlayingState{
-		IsPaused: isPaused,
-	}
-	err := pubsub.PublishJSON(channel,routing.ExchangePerilDirect, routing.PauseKey, playState)
+func publishPlayingState(channel *amqp.Channel, accounts *gamelogic.Accounts, key string, playState routing.PlayingState) error{
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, key, playState, gamelogic.ServerSigner, accounts.ServerKey())
 	if err != nil{
 		return fmt.Errorf("failed to publish playing state: %v", err)
 	}
 	return nil
 }
 
+// applyPause pauses or resumes a single player, a single room or every room.
+func applyPause(connection *amqp.Connection, channel *amqp.Channel, presence *gamelogic.Presence, accounts *gamelogic.Accounts, rooms *roomRegistry, cmd gamelogic.PauseCommand) error{
+	if cmd.Player != ""{
+		room, ok := presence.Room(cmd.Player)
+		if !ok || room == ""{
+			return fmt.Errorf("%s is not playing in a room", cmd.Player)
+		}
+		world, ok := rooms.get(room)
+		if !ok{
+			return fmt.Errorf("unknown room %s", room)
+		}
+		err := publishPlayingState(channel, accounts, routing.RoomKey(room, routing.PauseKey, cmd.Player), cmd.State)
+		if err != nil{
+			return err
+		}
+		world.SetPlayerPaused(cmd.Player, cmd.State)
+		if !cmd.State.Expiry.IsZero(){
+			go expirePause(connection, accounts, world, cmd.Player, cmd.State.Expiry)
+		}
+		return nil
+	}
+
+	worlds := rooms.all()
+	if cmd.Room != ""{
+		world, ok := rooms.get(cmd.Room)
+		if !ok{
+			return fmt.Errorf("unknown room %s", cmd.Room)
+		}
+		worlds = []*gamelogic.World{world}
+	}
+	for _, world := range worlds{
+		err := publishPlayingState(channel, accounts, routing.RoomKey(world.Room(), routing.PauseKey), cmd.State)
+		if err != nil{
+			fmt.Printf("Failed to publish pause state to room %s\n", world.Room())
+			continue
+		}
+		world.SetPaused(cmd.State)
+		if !cmd.State.Expiry.IsZero(){
+			go expirePause(connection, accounts, world, "", cmd.State.Expiry)
+		}
+	}
+	return nil
+}
+
+// expirePause resumes a timed pause of a room, or of a single player, once it
+// runs out. Nothing happens if the pause was replaced in the meantime.
+func expirePause(connection *amqp.Connection, accounts *gamelogic.Accounts, world *gamelogic.World, username string, expiry time.Time){
+	time.Sleep(time.Until(expiry))
+	key := routing.RoomKey(world.Room(), routing.PauseKey)
+	if username != ""{
+		if !world.ExpirePlayerPause(username, expiry){
+			return
+		}
+		key = routing.RoomKey(world.Room(), routing.PauseKey, username)
+	} else if !world.ExpirePause(expiry){
+		return
+	}
+
+	channel, err := connection.Channel()
+	if err != nil{
+		fmt.Printf("Failed to open a channel: %v\n", err)
+		return
+	}
+	defer channel.Close()
+
+	resume := routing.PlayingState{
+		Reason: "The timed pause is over.",
+	}
+	err = publishPlayingState(channel, accounts, key, resume)
+	if err != nil{
+		fmt.Println(err)
+	}
+}
+
+// turnInterval is how often a turn ends, paying players for the locations they hold and supplying their units.
+const turnInterval = 30 * time.Second
+
+func runTurns(world *gamelogic.World, accounts *gamelogic.Accounts, connection *amqp.Connection){
+	channel, err := connection.Channel()
+	if err != nil{
+		fmt.Printf("Failed to open a channel for turns: %v\n", err)
+		return
+	}
+	defer channel.Close()
+
+	ticker := time.NewTicker(turnInterval)
+	defer ticker.Stop()
+	for range ticker.C{
+		// wars keep going while the game is paused, so do their deadlines
+		world.ExpireWars()
+		if world.IsPaused() || world.IsOver(){
+			continue
+		}
+		for _, update := range world.EndTurn(){
+			err := publishTreasuryUpdate(channel, accounts, world.Room(), update)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		for _, update := range world.Resupply(){
+			err := publishSupplyUpdate(channel, accounts, world.Room(), update)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		// attrition may have wiped out a location's last units
+		for _, change := range world.UpdateControl(){
+			err := publishControlChange(channel, accounts, world.Room(), change)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		if over, ok := world.CheckVictory(); ok{
+			err := publishGameOver(channel, accounts, world.Room(), over)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// publishGameOver announces the result to every client and writes it to the game log.
+func publishGameOver(channel *amqp.Channel, accounts *gamelogic.Accounts, room string, over gamelogic.GameOver) error{
+	fmt.Println()
+	fmt.Printf("==== Game Over in %s ====\n", room)
+	fmt.Println(over.Reason)
+	gamelogic.PrintStandings(over.Standings)
+	fmt.Print("> ")
+
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.RoomKey(room, routing.GameOverKey), over, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish game over: %v", err)
+	}
+	gameLog := routing.GameLog{
+		CurrentTime: over.EndedAt,
+		Message:     fmt.Sprintf("won the game in room %s. %s", room, over.Reason),
+		Username:    over.Winner,
+	}
+	err = pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, routing.GameLogSlug + "." + over.Winner, gameLog, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish game log: %v", err)
+	}
+	return nil
+}
+
+func handlerLog() func(routing.GameLog)(pubsub.AnkType){
+	return func(gameLog routing.GameLog)(pubsub.AnkType){
+		defer fmt.Print("> ")
+		err := gamelogic.WriteLog(gameLog)
+		if err != nil{
+			fmt.Println(err)
+			return pubsub.NackRequeue
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerKeys(keys *pubsub.KeyRing) func(gamelogic.KeyDirectory)(pubsub.AnkType){
+	return func(dir gamelogic.KeyDirectory)(pubsub.AnkType){
+		keys.Replace(dir.Keys)
+		keys.SetRequired(dir.SigningRequired)
+		return pubsub.Ack
+	}
+}
+
+// handlerChatLog writes room and team chat to the game log while chat logging is on. Whispers are never logged.
+func handlerChatLog(enabled *atomic.Bool) func(gamelogic.ChatMessage)(pubsub.AnkType){
+	return func(msg gamelogic.ChatMessage)(pubsub.AnkType){
+		if !enabled.Load(){
+			return pubsub.Ack
+		}
+		err := gamelogic.WriteLog(gamelogic.ChatLog(msg))
+		if err != nil{
+			// the log file is not going to start working because the message is tried again
+			fmt.Println(err)
+			return pubsub.NackDiscard
+		}
+		return pubsub.Ack
+	}
+}
+
+// handlerChatRelay hands whispers and team messages to their recipients' inboxes. The team is the one the
+// server put the sender on, whatever the message claims.
+func handlerChatRelay(world *gamelogic.World, accounts *gamelogic.Accounts, connection *amqp.Connection, logging *atomic.Bool) func(gamelogic.ChatMessage)(pubsub.AnkType){
+	return func(msg gamelogic.ChatMessage)(pubsub.AnkType){
+		recipients := []string{}
+		switch msg.Channel{
+			case gamelogic.ChatDirect:
+				if !slices.Contains(world.Usernames(), msg.To){
+					fmt.Printf("Discarding whisper of %s to %s, who is not in room %s\n", msg.Username, msg.To, world.Room())
+					return pubsub.NackDiscard
+				}
+				recipients = append(recipients, msg.To)
+			case gamelogic.ChatTeam:
+				team, teammates := world.Team(msg.Username)
+				if team == ""{
+					fmt.Printf("Discarding team message of %s, who is not on a team\n", msg.Username)
+					return pubsub.NackDiscard
+				}
+				msg.Team = team
+				recipients = teammates
+				if logging.Load(){
+					err := gamelogic.WriteLog(gamelogic.ChatLog(msg))
+					if err != nil{
+						fmt.Println(err)
+					}
+				}
+			default:
+				return pubsub.NackDiscard
+		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.NackRequeue
+		}
+		defer channel.Close()
+
+		for _, username := range recipients{
+			inbox, ok := accounts.Inbox(username, routing.ChatPrefix)
+			if !ok{
+				continue
+			}
+			err = pubsub.PublishSignedJSON(channel, routing.ExchangeDefault, inbox, msg, gamelogic.ServerSigner, accounts.ServerKey())
+			if err != nil{
+				fmt.Printf("failed to relay chat message: %v\n", err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
+
+func publishTreasuryUpdate(channel *amqp.Channel, accounts *gamelogic.Accounts, room string, update gamelogic.TreasuryUpdate) error{
+	key := routing.RoomKey(room, routing.TreasuryPrefix, update.Username)
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, key, update, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish treasury update: %v", err)
+	}
+	return nil
+}
+
+func publishSupplyUpdate(channel *amqp.Channel, accounts *gamelogic.Accounts, room string, update gamelogic.SupplyUpdate) error{
+	key := routing.RoomKey(room, routing.SupplyPrefix, update.Username)
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, key, update, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish supply update: %v", err)
+	}
+	return nil
+}
+
+// publishControlChange tells everyone in the room a territory changed hands.
+func publishControlChange(channel *amqp.Channel, accounts *gamelogic.Accounts, room string, change gamelogic.ControlChange) error{
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.RoomKey(room, routing.ControlKey), change, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish control change: %v", err)
+	}
+	return nil
+}
+
+// handlerWarOutcome applies a war resolution or ack the server overheard, and announces the territories it
+// changed hands.
+func handlerWarOutcome[T any](world *gamelogic.World, accounts *gamelogic.Accounts, connection *amqp.Connection, apply func(T) bool) func(T)(pubsub.AnkType){
+	return func(val T)(pubsub.AnkType){
+		if !apply(val){
+			return pubsub.Ack
+		}
+		controlChanges := world.UpdateControl()
+		if len(controlChanges) == 0{
+			return pubsub.Ack
+		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.Ack
+		}
+		defer channel.Close()
+
+		for _, change := range controlChanges{
+			err = publishControlChange(channel, accounts, world.Room(), change)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
+
+// handlerDiplomacy records the treaties players agree to, for the battlefields the server hands out.
+func handlerDiplomacy(world *gamelogic.World) func(gamelogic.DiplomacyMessage)(pubsub.AnkType){
+	return func(msg gamelogic.DiplomacyMessage)(pubsub.AnkType){
+		world.HandleDiplomacy(msg)
+		return pubsub.Ack
+	}
+}
+
+// handlerMove passes a move on to the players that can see where it went, each through its own inbox, so
+// nobody else learns about it.
+func handlerMove(world *gamelogic.World, accounts *gamelogic.Accounts, connection *amqp.Connection) func(gamelogic.ArmyMove)(pubsub.AnkType){
+	return func(am gamelogic.ArmyMove)(pubsub.AnkType){
+		// the server's records only move with the moves it sees
+		world.HandleMove(am)
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.NackRequeue
+		}
+		defer channel.Close()
+
+		for _, username := range world.Witnesses(am){
+			inbox, ok := accounts.Inbox(username, routing.ArmyMovesPrefix)
+			if !ok{
+				continue
+			}
+			err = pubsub.PublishSignedJSON(channel, routing.ExchangeDefault, inbox, am, gamelogic.ServerSigner, accounts.ServerKey())
+			if err != nil{
+				fmt.Printf("failed to relay move: %v\n", err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerPlayerState(world *gamelogic.World, accounts *gamelogic.Accounts, connection *amqp.Connection) func(gamelogic.PlayerState)(pubsub.AnkType){
+	return func(ps gamelogic.PlayerState)(pubsub.AnkType){
+		if !accounts.Verify(ps.Player.Username, ps.Token){
+			fmt.Printf("Discarding state of unauthenticated %s\n", ps.Player.Username)
+			return pubsub.NackDiscard
+		}
+		update, changed := world.HandlePlayerState(ps.Player)
+		controlChanges := world.UpdateControl()
+		over, ended := world.CheckVictory()
+		if !changed && !ended && len(controlChanges) == 0{
+			return pubsub.Ack
+		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.Ack
+		}
+		defer channel.Close()
+
+		// The state is already recorded, so a failed update must not requeue it.
+		if changed{
+			err = publishTreasuryUpdate(channel, accounts, world.Room(), update)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		for _, change := range controlChanges{
+			err = publishControlChange(channel, accounts, world.Room(), change)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		if ended{
+			err = publishGameOver(channel, accounts, world.Room(), over)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
+
+func handlerLobby(lobby *gamelogic.Lobby, accounts *gamelogic.Accounts, rooms *roomRegistry, connection *amqp.Connection) func(gamelogic.LobbyRequest)(pubsub.AnkType){
+	return func(req gamelogic.LobbyRequest)(pubsub.AnkType){
+		if !accounts.Verify(req.Username, req.Token){
+			fmt.Printf("Discarding lobby request from unauthenticated %s\n", req.Username)
+			return pubsub.NackDiscard
+		}
+		responses, start := lobby.HandleRequest(req)
+		// The lobby never starts a game in a room that is already running.
+		if start != ""{
+			_, err := rooms.create(start)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return pubsub.NackRequeue
+		}
+		defer channel.Close()
+
+		for username, resp := range responses{
+			err = pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.LobbyKey + "." + username, resp, gamelogic.ServerSigner, accounts.ServerKey())
+			if err != nil{
+				fmt.Printf("failed to publish lobby response: %v\n", err)
+			}
+		}
+		return pubsub.Ack
+	}
+}
+
+// handlerHeartbeat keeps a client connected. The heartbeats of a session that ended are refused, so the client
+// finds out and can log in again.
+func handlerHeartbeat(presence *gamelogic.Presence, accounts *gamelogic.Accounts, lobby *gamelogic.Lobby, rooms *roomRegistry, connection *amqp.Connection) func(gamelogic.Heartbeat)(gamelogic.HeartbeatReply, error){
+	return func(hb gamelogic.Heartbeat)(gamelogic.HeartbeatReply, error){
+		if !accounts.Verify(hb.Username, hb.Token){
+			return gamelogic.HeartbeatReply{}, fmt.Errorf("the session of %s ended", hb.Username)
+		}
+		event, changed := presence.HandleHeartbeat(hb)
+		if !changed{
+			return gamelogic.HeartbeatReply{}, nil
+		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return gamelogic.HeartbeatReply{}, nil
+		}
+		defer channel.Close()
+
+		err = publishPresenceEvent(channel, accounts, lobby, rooms, event)
+		if err != nil{
+			fmt.Println(err)
+		}
+		return gamelogic.HeartbeatReply{}, nil
+	}
+}
+
+// sweepPresence times out every client that stopped sending heartbeats.
+func sweepPresence(presence *gamelogic.Presence, accounts *gamelogic.Accounts, lobby *gamelogic.Lobby, rooms *roomRegistry, connection *amqp.Connection){
+	channel, err := connection.Channel()
+	if err != nil{
+		fmt.Printf("Failed to open a channel for presence: %v\n", err)
+		return
+	}
+	defer channel.Close()
+
+	ticker := time.NewTicker(gamelogic.HeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C{
+		for _, event := range presence.Sweep(gamelogic.PresenceTimeoutAfter){
+			err := publishPresenceEvent(channel, accounts, lobby, rooms, event)
+			if err != nil{
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// publishPresenceEvent tells every client who came and went. Players that are gone are dropped from the lobby and
+// their room, players that timed out keep their place in the room until they are back.
+func publishPresenceEvent(channel *amqp.Channel, accounts *gamelogic.Accounts, lobby *gamelogic.Lobby, rooms *roomRegistry, event gamelogic.PresenceEvent) error{
+	if event.Kind != gamelogic.PresenceJoin{
+		accounts.EndSession(event.Username)
+		lobby.Leave(event.Username)
+		if world, ok := rooms.get(event.Room); ok && event.Kind == gamelogic.PresenceTimeout{
+			world.DisconnectPlayer(event.Username)
+		} else if ok{
+			world.RemovePlayer(event.Username)
+			for _, change := range world.UpdateControl(){
+				err := publishControlChange(channel, accounts, world.Room(), change)
+				if err != nil{
+					return err
+				}
+			}
+		}
+		err := publishKeyDirectory(channel, accounts)
+		if err != nil{
+			return err
+		}
+	}
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilTopic, routing.PresencePrefix + "." + event.Username, event, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish presence event: %v", err)
+	}
+	return nil
+}
+
+// kickPlayer tells a player it was removed and ends its session, so anything
+// it still publishes is ignored by the server and fails verification everywhere else.
+func kickPlayer(channel *amqp.Channel, presence *gamelogic.Presence, accounts *gamelogic.Accounts, lobby *gamelogic.Lobby, rooms *roomRegistry, username string, action gamelogic.AdminAction, reason string) error{
+	msg := gamelogic.AdminMessage{
+		Action:   action,
+		Username: username,
+		Message:  reason,
+		SentAt:   time.Now(),
+	}
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.AdminPrefix + "." + username, msg, gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish admin message: %v", err)
+	}
+	event, ok := presence.Remove(username)
+	if !ok{
+		// not connected, but a stale session must not survive the kick either
+		accounts.EndSession(username)
+		return publishKeyDirectory(channel, accounts)
+	}
+	return publishPresenceEvent(channel, accounts, lobby, rooms, event)
+}
+
+// publishKeyDirectory hands every client the keys of the players currently logged in, signed with the key
+// they pinned when they logged in.
+func publishKeyDirectory(channel *amqp.Channel, accounts *gamelogic.Accounts) error{
+	err := pubsub.PublishSignedJSON(channel, routing.ExchangePerilDirect, routing.KeysKey, accounts.KeyDirectory(), gamelogic.ServerSigner, accounts.ServerKey())
+	if err != nil{
+		return fmt.Errorf("failed to publish key directory: %v", err)
+	}
+	return nil
+}
+
+func handlerAuth(accounts *gamelogic.Accounts, presence *gamelogic.Presence, connection *amqp.Connection) func(gamelogic.AuthRequest)(gamelogic.AuthResponse, error){
+	return func(req gamelogic.AuthRequest)(gamelogic.AuthResponse, error){
+		resp := accounts.HandleAuth(req, presence.IsConnected(req.Username))
+		if !resp.OK{
+			return resp, nil
+		}
+
+		channel, err := connection.Channel()
+		if err != nil{
+			fmt.Printf("Failed to open a channel: %v\n", err)
+			return resp, nil
+		}
+		defer channel.Close()
+
+		err = publishKeyDirectory(channel, accounts)
+		if err != nil{
+			fmt.Println(err)
+		}
+		return resp, nil
+	}
+}
+
+// handlerSync tells a client that just joined a room everything it missed: pauses, players and its own state.
+func handlerSync(accounts *gamelogic.Accounts, rooms *roomRegistry) func(gamelogic.SyncRequest)(gamelogic.WorldSnapshot, error){
+	return func(req gamelogic.SyncRequest)(gamelogic.WorldSnapshot, error){
+		if !accounts.Verify(req.Username, req.Token){
+			fmt.Printf("Refusing sync request from unauthenticated %s\n", req.Username)
+			return gamelogic.WorldSnapshot{}, fmt.Errorf("you are not signed in as %s", req.Username)
+		}
+		world, ok := rooms.get(req.Room)
+		if !ok{
+			return gamelogic.WorldSnapshot{}, fmt.Errorf("room %s is not hosted by this server", req.Room)
+		}
+		return world.Snapshot(req.Username), nil
+	}
+}
+
+// handlerTeam puts players on teams. Only the server keeps teams, so nobody can read a team's chat by
+// claiming to be on it.
+func handlerTeam(accounts *gamelogic.Accounts, rooms *roomRegistry) func(gamelogic.TeamRequest)(gamelogic.TeamResponse, error){
+	return func(req gamelogic.TeamRequest)(gamelogic.TeamResponse, error){
+		if !accounts.Verify(req.Username, req.Token){
+			fmt.Printf("Refusing team request from unauthenticated %s\n", req.Username)
+			return gamelogic.TeamResponse{}, fmt.Errorf("you are not signed in as %s", req.Username)
+		}
+		world, ok := rooms.get(req.Room)
+		if !ok{
+			return gamelogic.TeamResponse{}, fmt.Errorf("room %s is not hosted by this server", req.Room)
+		}
+		return world.HandleTeamRequest(req)
+	}
+}
+
+// handlerBattlefield tells a defender who really is in the location it was attacked in, so its war is fought
+// by the players there and not by the ones its intel remembers.
+func handlerBattlefield(accounts *gamelogic.Accounts, rooms *roomRegistry) func(gamelogic.BattlefieldRequest)(gamelogic.Battlefield, error){
+	return func(req gamelogic.BattlefieldRequest)(gamelogic.Battlefield, error){
+		if !accounts.Verify(req.Username, req.Token){
+			fmt.Printf("Refusing battlefield request from unauthenticated %s\n", req.Username)
+			return gamelogic.Battlefield{}, fmt.Errorf("you are not signed in as %s", req.Username)
+		}
+		world, ok := rooms.get(req.Room)
+		if !ok{
+			return gamelogic.Battlefield{}, fmt.Errorf("room %s is not hosted by this server", req.Room)
+		}
+		return world.Battlefield(req.Username, req.Location)
+	}
+}
+
 func exitFromOSSignal(){
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal, os.Interrupt)
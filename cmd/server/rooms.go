@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/gamelogic"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/pubsub"
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// roomRegistry holds every game the server hosts. Rooms are created from the
+// REPL and by the lobby, so access is guarded by a mutex.
+type roomRegistry struct {
+	connection  *amqp.Connection
+	accounts    *gamelogic.Accounts
+	keys        *pubsub.KeyRing
+	// whether team chat is written to the game log
+	chatLogging *atomic.Bool
+	worlds      map[string]*gamelogic.World
+	mu          *sync.Mutex
+}
+
+func newRoomRegistry(connection *amqp.Connection, accounts *gamelogic.Accounts, keys *pubsub.KeyRing, chatLogging *atomic.Bool) *roomRegistry{
+	return &roomRegistry{
+		connection:  connection,
+		accounts:    accounts,
+		keys:        keys,
+		chatLogging: chatLogging,
+		worlds:      map[string]*gamelogic.World{},
+		mu:          &sync.Mutex{},
+	}
+}
+
+// create starts a new game, subscribing to its player states, moves, war outcomes, diplomacy and private chat
+// and running its turns.
+func (r *roomRegistry) create(room string) (*gamelogic.World, error){
+	err := routing.ValidateRoom(room)
+	if err != nil{
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.worlds[room]; ok{
+		return nil, fmt.Errorf("room %s already exists", room)
+	}
+
+	world := gamelogic.NewWorld(room)
+	// Player states and moves come in on the default exchange, so only the server reads them and decides who
+	// may see the moves.
+	playerStatesKey := routing.RoomKey(room, routing.PlayerStatesKey)
+	err = pubsub.SubscribeJSON(r.connection, routing.ExchangeDefault, playerStatesKey, "", pubsub.QueueTypeTransient, handlerPlayerState(world, r.accounts, r.connection))
+	if err != nil{
+		return nil, fmt.Errorf("failed to subscribe to player states of room %s: %v", room, err)
+	}
+	movesQueueName := routing.RoomKey(room, routing.ArmyMovesPrefix)
+	err = pubsub.SubscribeVerifiedJSON(r.connection, routing.ExchangeDefault, movesQueueName, "", pubsub.QueueTypeTransient, r.keys, func(am gamelogic.ArmyMove) string { return am.Player.Username }, handlerMove(world, r.accounts, r.connection))
+	if err != nil{
+		return nil, fmt.Errorf("failed to subscribe to moves of room %s: %v", room, err)
+	}
+	// The server overhears the war outcomes players send each other, so control changes as soon as the
+	// casualties are agreed on.
+	warResolutionsQueueName := routing.RoomKey(room, routing.WarResolutionsPrefix)
+	err = pubsub.SubscribeVerifiedJSON(r.connection, routing.ExchangePerilTopic, warResolutionsQueueName, routing.RoomKey(room, routing.WarResolutionsPrefix, "*"), pubsub.QueueTypeTransient, r.keys, func(res gamelogic.WarResolution) string { return res.Attacker }, handlerWarOutcome(world, r.accounts, r.connection, world.HandleWarResolution))
+	if err != nil{
+		return nil, fmt.Errorf("failed to subscribe to war resolutions of room %s: %v", room, err)
+	}
+	warAcksQueueName := routing.RoomKey(room, routing.WarAcksPrefix)
+	err = pubsub.SubscribeVerifiedJSON(r.connection, routing.ExchangePerilTopic, warAcksQueueName, routing.RoomKey(room, routing.WarAcksPrefix, "*"), pubsub.QueueTypeTransient, r.keys, func(ack gamelogic.WarAck) string { return ack.Username }, handlerWarOutcome(world, r.accounts, r.connection, world.HandleWarAck))
+	if err != nil{
+		return nil, fmt.Errorf("failed to subscribe to war acks of room %s: %v", room, err)
+	}
+	// Diplomacy is overheard too, so everyone in a battlefield learns who is at peace with whom from the server.
+	diplomacyQueueName := routing.RoomKey(room, routing.DiplomacyPrefix)
+	err = pubsub.SubscribeVerifiedJSON(r.connection, routing.ExchangePerilTopic, diplomacyQueueName, routing.RoomKey(room, routing.DiplomacyPrefix, "*"), pubsub.QueueTypeTransient, r.keys, func(msg gamelogic.DiplomacyMessage) string { return msg.From }, handlerDiplomacy(world))
+	if err != nil{
+		return nil, fmt.Errorf("failed to subscribe to diplomacy of room %s: %v", room, err)
+	}
+	chatQueueName := routing.RoomKey(room, routing.ChatPrefix)
+	err = pubsub.SubscribeVerifiedJSON(r.connection, routing.ExchangeDefault, chatQueueName, "", pubsub.QueueTypeTransient, r.keys, func(msg gamelogic.ChatMessage) string { return msg.Username }, handlerChatRelay(world, r.accounts, r.connection, r.chatLogging))
+	if err != nil{
+		return nil, fmt.Errorf("failed to subscribe to chat of room %s: %v", room, err)
+	}
+	go runTurns(world, r.accounts, r.connection)
+	r.worlds[room] = world
+	return world, nil
+}
+
+func (r *roomRegistry) get(room string) (*gamelogic.World, bool){
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	world, ok := r.worlds[room]
+	return world, ok
+}
+
+// all returns every room sorted by name.
+func (r *roomRegistry) all() []*gamelogic.World{
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	worlds := []*gamelogic.World{}
+	for _, world := range r.worlds{
+		worlds = append(worlds, world)
+	}
+	sort.Slice(worlds, func(i, j int) bool { return worlds[i].Room() < worlds[j].Room() })
+	return worlds
+}
@@ -0,0 +1,166 @@
+package gamelogic
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type AdminAction string
+
+const (
+	AdminActionKick     = "kick"
+	AdminActionBan      = "ban"
+	AdminActionAnnounce = "announce"
+	AdminActionReset    = "reset"
+)
+
+// adminMessageMaxAge is how long an admin message is acted on, so a captured
+// kick or reset can not be replayed later.
+const adminMessageMaxAge = time.Minute
+
+// AdminMessage is sent by the server operator. Kicks and bans go to a single
+// player, named by Username, announcements to everyone and resets to every
+// player of Room.
+type AdminMessage struct {
+	Action   AdminAction
+	Username string
+	Room     string
+	Message  string
+	SentAt   time.Time
+}
+
+// HandleAdmin shows an admin message and applies it. Messages meant for
+// another player or room, or sent too long ago, are ignored. It reports
+// whether the player was removed from the game and must quit.
+func (gs *GameState) HandleAdmin(msg AdminMessage) bool {
+	if !gs.acceptsAdmin(msg) {
+		return false
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	switch msg.Action {
+	case AdminActionAnnounce:
+		fmt.Println("==== Announcement ====")
+		fmt.Println(msg.Message)
+	case AdminActionReset:
+		fmt.Println("==== Game Reset ====")
+		gs.reset()
+		fmt.Printf("The game in room %s was reset. Your units are gone and your treasury holds %v gold.\n", msg.Room, StartingTreasury)
+	case AdminActionKick, AdminActionBan:
+		fmt.Println("==== Removed ====")
+		verb := "kicked"
+		if msg.Action == AdminActionBan {
+			verb = "banned"
+		}
+		fmt.Printf("You were %s from the server.\n", verb)
+		if msg.Message != "" {
+			fmt.Printf("Reason: %s\n", msg.Message)
+		}
+		return true
+	}
+	return false
+}
+
+// acceptsAdmin reports whether msg is recent and addressed to the player.
+func (gs *GameState) acceptsAdmin(msg AdminMessage) bool {
+	if time.Since(msg.SentAt) > adminMessageMaxAge {
+		return false
+	}
+	switch msg.Action {
+	case AdminActionKick, AdminActionBan:
+		return msg.Username == gs.GetUsername()
+	case AdminActionReset:
+		return msg.Room != "" && msg.Room == gs.GetRoom()
+	}
+	return true
+}
+
+// reset starts the player over. Unit IDs keep counting so that units of the
+// old game can never be confused with new ones.
+func (gs *GameState) reset() {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	gs.Paused = false
+	gs.pause = routing.PlayingState{}
+	gs.treasury = StartingTreasury
+	gs.home = ""
+	gs.outOfSupply = []Location{}
+	gs.team = ""
+	gs.territories = map[Location]Territory{}
+	gs.intel = map[string]map[Location]Sighting{}
+	gs.pendingWars = map[string]RecognitionOfWar{}
+	gs.heldResolutions = map[string]WarResolution{}
+	gs.openWars = map[string]openWar{}
+	gs.undecidedWars = map[string]RecognitionOfWar{}
+	gs.sentDecisions = map[string]WarDecision{}
+	gs.unconfirmedWars = map[string]unconfirmedWar{}
+	gs.treaties = map[string]Treaty{}
+	gs.proposalsSent = map[string]DiplomacyMessage{}
+	gs.proposalsReceived = map[string]DiplomacyMessage{}
+}
+
+// Reset forgets every player and starts the game over, keeping its victory
+// condition.
+func (w *World) Reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.players = map[string]Player{}
+	w.treasuries = map[string]int{}
+	w.pause = routing.PlayingState{}
+	w.playerPauses = map[string]routing.PlayingState{}
+	w.startedAt = time.Now()
+	w.fielded = map[string]struct{}{}
+	w.homes = map[string]Location{}
+	w.cutOff = map[string][]Location{}
+	w.dead = map[string]map[int]struct{}{}
+	w.control = map[Location]Territory{}
+	w.controlStreaks = map[string]int{}
+	w.disconnected = map[string]struct{}{}
+	w.resolutions = map[string]WarResolution{}
+	w.agreed = map[string]map[string]struct{}{}
+	w.warsSeen = map[string]time.Time{}
+	w.teams = map[string]string{}
+	w.invites = map[string]map[string]struct{}{}
+	w.treaties = map[string]map[string]Treaty{}
+	w.proposals = map[string]map[string]DiplomacyMessage{}
+	w.over = false
+}
+
+// Remove disconnects a player the server kicked.
+func (p *Presence) Remove(username string) (PresenceEvent, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.entries[username]
+	if !ok {
+		return PresenceEvent{}, false
+	}
+	delete(p.entries, username)
+	return PresenceEvent{
+		Username: username,
+		Room:     entry.Room,
+		Kind:     PresenceKick,
+		At:       time.Now(),
+	}, true
+}
+
+// Ban keeps username from logging in again.
+func (a *Accounts) Ban(username string, banned bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	acc, ok := a.accounts[username]
+	if !ok {
+		return fmt.Errorf("error: %s has no account", username)
+	}
+	acc.Banned = banned
+	a.accounts[username] = acc
+	err := a.save()
+	if err != nil {
+		acc.Banned = !banned
+		a.accounts[username] = acc
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package gamelogic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandleAdminIgnoresMessagesForOthers(t *testing.T) {
+	gs := NewGameState("alice")
+	if err := gs.JoinRoom("r1"); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		msg  AdminMessage
+		quit bool
+	}{
+		{"kick for alice", AdminMessage{Action: AdminActionKick, Username: "alice", SentAt: time.Now()}, true},
+		{"kick for bob", AdminMessage{Action: AdminActionKick, Username: "bob", SentAt: time.Now()}, false},
+		{"ban without a target", AdminMessage{Action: AdminActionBan, SentAt: time.Now()}, false},
+		{"replayed kick", AdminMessage{Action: AdminActionKick, Username: "alice", SentAt: time.Now().Add(-2 * adminMessageMaxAge)}, false},
+	}
+	for _, c := range cases {
+		if got := gs.HandleAdmin(c.msg); got != c.quit {
+			t.Errorf("%s: expected quit %v, got %v", c.name, c.quit, got)
+		}
+	}
+}
+
+func TestHandleAdminOnlyResetsTheJoinedRoom(t *testing.T) {
+	gs := NewGameState("alice")
+	if err := gs.JoinRoom("r1"); err != nil {
+		t.Fatal(err)
+	}
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+
+	gs.HandleAdmin(AdminMessage{Action: AdminActionReset, Room: "r2", SentAt: time.Now()})
+	gs.HandleAdmin(AdminMessage{Action: AdminActionReset, Room: "r1", SentAt: time.Now().Add(-2 * adminMessageMaxAge)})
+	if len(gs.getUnitsSnap()) != 1 {
+		t.Fatal("expected resets of another room or replayed resets to be ignored")
+	}
+	gs.HandleAdmin(AdminMessage{Action: AdminActionReset, Room: "r1", SentAt: time.Now()})
+	if len(gs.getUnitsSnap()) != 0 {
+		t.Error("expected the reset of the joined room to wipe the units")
+	}
+}
@@ -0,0 +1,301 @@
+package gamelogic
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type AuthAction string
+
+const (
+	AuthActionRegister = "register"
+	AuthActionLogin    = "login"
+)
+
+const accountsFile = "accounts.json"
+
+// ServerSigner is who signs what the server publishes, like the key
+// directory. Players pin the server's key when they log in.
+const ServerSigner = "server"
+
+// AuthRequest is sent by a client before it can play. Inboxes are the
+// private queues the server delivers the client's messages to, keyed by the
+// routing prefix of what they carry.
+type AuthRequest struct {
+	Action    AuthAction
+	Username  string
+	Password  string
+	PublicKey []byte
+	Inboxes   map[string]string
+}
+
+type AuthResponse struct {
+	OK        bool
+	Token     string
+	Message   string
+	ServerKey []byte
+}
+
+// KeyDirectory is broadcast by the server whenever someone logs in or out,
+// so clients can verify the signatures of every player's messages. It is
+// signed by the server. SigningRequired is off when the server lets
+// unsigned player messages through.
+type KeyDirectory struct {
+	Keys            map[string][]byte
+	SigningRequired bool
+}
+
+// PlayerState is a client's full state as reported to the server.
+type PlayerState struct {
+	Token  string
+	Player Player
+}
+
+type account struct {
+	// bcrypt hash of the password
+	Hash   string
+	Banned bool `json:",omitempty"`
+}
+
+// Accounts is run by the server. It stores registered players and hands out
+// the session tokens that tie their messages to them.
+type Accounts struct {
+	accounts map[string]account
+	// session token per logged in username
+	sessions map[string]string
+	// signing key each logged in username registered
+	publicKeys map[string][]byte
+	// private queues of each logged in username, by routing prefix
+	inboxes         map[string]map[string]string
+	signingRequired bool
+	// the server's own signing key, new every time the server starts
+	serverKey ed25519.PrivateKey
+	path      string
+	mu        *sync.Mutex
+}
+
+// NewAccounts loads the registered accounts from disk, if there are any.
+func NewAccounts() (*Accounts, error) {
+	_, serverKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate the server's signing key: %v", err)
+	}
+	a := &Accounts{
+		accounts:        map[string]account{},
+		sessions:        map[string]string{},
+		publicKeys:      map[string][]byte{},
+		inboxes:         map[string]map[string]string{},
+		signingRequired: true,
+		serverKey:       serverKey,
+		path:            accountsFile,
+		mu:              &sync.Mutex{},
+	}
+	data, err := os.ReadFile(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read accounts file: %v", err)
+	}
+	err = json.Unmarshal(data, &a.accounts)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse accounts file: %v", err)
+	}
+	return a, nil
+}
+
+// ClientCredentials asks the player how to authenticate. publicKey is the
+// key the player's messages will be signed with.
+func ClientCredentials(username string, publicKey []byte) (AuthRequest, error) {
+	fmt.Println("Type 'register <password>' to create an account or 'login <password>' to sign in:")
+	req, err := ParseCredentials(GetInput(), username, publicKey)
+	if err != nil {
+		return AuthRequest{}, fmt.Errorf("%v. goodbye", err)
+	}
+	return req, nil
+}
+
+// ParseCredentials reads 'register <password>' or 'login <password>'.
+func ParseCredentials(words []string, username string, publicKey []byte) (AuthRequest, error) {
+	if len(words) < 2 || (words[0] != AuthActionRegister && words[0] != AuthActionLogin) {
+		return AuthRequest{}, errors.New("you must register or log in")
+	}
+	return AuthRequest{
+		Action:    AuthAction(words[0]),
+		Username:  username,
+		Password:  words[1],
+		PublicKey: publicKey,
+	}, nil
+}
+
+// SignedIn reports whether the player has a session with the server.
+func (gs *GameState) SignedIn() bool {
+	return gs.getToken() != ""
+}
+
+// HandleSessionEnded forgets the session token belongs to once the server
+// no longer accepts it, so the player can log in again. It reports whether
+// it was still the player's session, a newer one is kept.
+func (gs *GameState) HandleSessionEnded(token, reason string) bool {
+	if !gs.endSession(token) {
+		return false
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Session Ended ====")
+	fmt.Println(reason)
+	fmt.Println("Type 'login <password>' to sign in again.")
+	return true
+}
+
+func (gs *GameState) endSession(token string) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.token == "" || gs.token != token {
+		return false
+	}
+	gs.token = ""
+	return true
+}
+
+// HandleAuth registers or logs in a player. connected reports whether the
+// username is already in use by a connected client.
+func (a *Accounts) HandleAuth(req AuthRequest, connected bool) AuthResponse {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch req.Action {
+	case AuthActionRegister:
+		if req.Username == ServerSigner {
+			return AuthResponse{Message: fmt.Sprintf("the username %s is reserved", req.Username)}
+		}
+		// usernames end up in routing keys, wildcards would bind to other players' messages
+		err := routing.ValidateName(req.Username)
+		if err != nil {
+			return AuthResponse{Message: err.Error()}
+		}
+		if _, ok := a.accounts[req.Username]; ok {
+			return AuthResponse{Message: fmt.Sprintf("the username %s is already registered", req.Username)}
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return AuthResponse{Message: fmt.Sprintf("could not hash password: %v", err)}
+		}
+		a.accounts[req.Username] = account{
+			Hash: string(hash),
+		}
+		err = a.save()
+		if err != nil {
+			delete(a.accounts, req.Username)
+			return AuthResponse{Message: err.Error()}
+		}
+	case AuthActionLogin:
+		acc, ok := a.accounts[req.Username]
+		if !ok || bcrypt.CompareHashAndPassword([]byte(acc.Hash), []byte(req.Password)) != nil {
+			return AuthResponse{Message: "wrong username or password"}
+		}
+		if acc.Banned {
+			return AuthResponse{Message: fmt.Sprintf("%s is banned from this server", req.Username)}
+		}
+		if connected {
+			return AuthResponse{Message: fmt.Sprintf("%s is already playing", req.Username)}
+		}
+	default:
+		return AuthResponse{Message: fmt.Sprintf("unknown auth action %s", req.Action)}
+	}
+
+	token, err := randomHex()
+	if err != nil {
+		return AuthResponse{Message: err.Error()}
+	}
+	a.sessions[req.Username] = token
+	a.publicKeys[req.Username] = req.PublicKey
+	a.inboxes[req.Username] = req.Inboxes
+	return AuthResponse{
+		OK:        true,
+		Token:     token,
+		Message:   fmt.Sprintf("Signed in as %s.", req.Username),
+		ServerKey: a.serverKey.Public().(ed25519.PublicKey),
+	}
+}
+
+// Verify reports whether token is the current session of username.
+func (a *Accounts) Verify(username, token string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	session, ok := a.sessions[username]
+	return ok && subtle.ConstantTimeCompare([]byte(session), []byte(token)) == 1
+}
+
+func (a *Accounts) EndSession(username string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, username)
+	delete(a.publicKeys, username)
+	delete(a.inboxes, username)
+}
+
+// Inbox is the private queue a logged in player receives the messages
+// routed under prefix on.
+func (a *Accounts) Inbox(username, prefix string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inbox, ok := a.inboxes[username][prefix]
+	return inbox, ok
+}
+
+func (a *Accounts) KeyDirectory() KeyDirectory {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := map[string][]byte{}
+	for username, key := range a.publicKeys {
+		keys[username] = key
+	}
+	return KeyDirectory{
+		Keys:            keys,
+		SigningRequired: a.signingRequired,
+	}
+}
+
+// SetSigningRequired decides whether players must sign their messages.
+func (a *Accounts) SetSigningRequired(required bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.signingRequired = required
+}
+
+// ServerKey is what the server signs its messages with.
+func (a *Accounts) ServerKey() ed25519.PrivateKey {
+	return a.serverKey
+}
+
+// save must be called with the lock held.
+func (a *Accounts) save() error {
+	data, err := json.MarshalIndent(a.accounts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode accounts: %v", err)
+	}
+	err = os.WriteFile(a.path, data, 0600)
+	if err != nil {
+		return fmt.Errorf("could not write accounts file: %v", err)
+	}
+	return nil
+}
+
+func randomHex() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("could not generate random value: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,95 @@
+package gamelogic
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestAccounts(t *testing.T) *Accounts {
+	t.Helper()
+	a, err := NewAccounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.accounts = map[string]account{}
+	a.path = filepath.Join(t.TempDir(), accountsFile)
+	return a
+}
+
+func TestParseCredentials(t *testing.T) {
+	req, err := ParseCredentials([]string{"login", "hunter2"}, "alice", []byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Action != AuthActionLogin || req.Username != "alice" || req.Password != "hunter2" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	for _, words := range [][]string{{}, {"login"}, {"signup", "hunter2"}} {
+		if _, err := ParseCredentials(words, "alice", nil); err == nil {
+			t.Errorf("expected %v to be refused", words)
+		}
+	}
+}
+
+func TestLoggingInAgainAfterTheSessionEnded(t *testing.T) {
+	a := newTestAccounts(t)
+	resp := a.HandleAuth(AuthRequest{Action: AuthActionRegister, Username: "alice", Password: "hunter2"}, false)
+	if !resp.OK {
+		t.Fatal(resp.Message)
+	}
+	a.EndSession("alice")
+	if a.Verify("alice", resp.Token) {
+		t.Fatal("expected the ended session to be refused")
+	}
+
+	again := a.HandleAuth(AuthRequest{
+		Action:   AuthActionLogin,
+		Username: "alice",
+		Password: "hunter2",
+		Inboxes:  map[string]string{"army_moves": "amq.gen-1"},
+	}, false)
+	if !again.OK || !a.Verify("alice", again.Token) {
+		t.Fatalf("expected alice to log in again, got %q", again.Message)
+	}
+	if inbox, ok := a.Inbox("alice", "army_moves"); !ok || inbox != "amq.gen-1" {
+		t.Errorf("expected the new session's inbox, got %q", inbox)
+	}
+}
+
+func TestHandleSessionEndedKeepsANewerSession(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.SetToken("new")
+	if gs.HandleSessionEnded("old", "the session of alice ended") {
+		t.Error("expected a refused heartbeat of an old session not to end the new one")
+	}
+	if !gs.SignedIn() {
+		t.Error("expected alice to still be signed in")
+	}
+	if !gs.HandleSessionEnded("new", "the session of alice ended") || gs.SignedIn() {
+		t.Error("expected the current session to end")
+	}
+}
+
+func TestRegisterRefusesRoutingWildcards(t *testing.T) {
+	a := newTestAccounts(t)
+	for _, username := range []string{ServerSigner, "#", "*", "a.b"} {
+		resp := a.HandleAuth(AuthRequest{Action: AuthActionRegister, Username: username, Password: "hunter2"}, false)
+		if resp.OK {
+			t.Errorf("expected %q to be refused", username)
+		}
+	}
+}
+
+func TestLoginChecksThePassword(t *testing.T) {
+	a := newTestAccounts(t)
+	a.HandleAuth(AuthRequest{Action: AuthActionRegister, Username: "alice", Password: "hunter2"}, false)
+	if acc := a.accounts["alice"]; acc.Hash == "" || acc.Hash == "hunter2" {
+		t.Fatalf("expected the password to be stored hashed, got %q", acc.Hash)
+	}
+	if resp := a.HandleAuth(AuthRequest{Action: AuthActionLogin, Username: "alice", Password: "hunter3"}, false); resp.OK {
+		t.Error("expected the wrong password to be refused")
+	}
+	if resp := a.HandleAuth(AuthRequest{Action: AuthActionLogin, Username: "alice", Password: "hunter2"}, false); !resp.OK {
+		t.Errorf("expected the right password to log in, got %q", resp.Message)
+	}
+}
@@ -0,0 +1,148 @@
+package gamelogic
+
+import (
+	"errors"
+	"slices"
+)
+
+// BattlefieldRequest is sent by a defender before it declares war, so the
+// war is fought by the players really in the location rather than the ones
+// its intel last saw there.
+type BattlefieldRequest struct {
+	Username string
+	Token    string
+	Room     string
+	Location Location
+}
+
+// Battlefield is every player the server knows to have units in a
+// location, stripped down to those units and sorted by username. CutOff are
+// the players whose units there the server found out of supply at the end
+// of the last turn. Treaties are the treaties in force between the players
+// there, as the server saw them agreed, keyed by player.
+type Battlefield struct {
+	Location Location
+	Players  []Player
+	CutOff   []string
+	Treaties map[string][]Treaty
+}
+
+// NewBattlefieldRequest asks for the location the attacker moved into
+// where the player has units.
+func (gs *GameState) NewBattlefieldRequest(attacker Player) BattlefieldRequest {
+	return BattlefieldRequest{
+		Username: gs.GetUsername(),
+		Token:    gs.getToken(),
+		Room:     gs.GetRoom(),
+		Location: getOverlappingLocation(attacker, gs.GetPlayerSnap()),
+	}
+}
+
+// Battlefield answers a player that can see loc with everyone in it.
+func (w *World) Battlefield(username string, loc Location) (Battlefield, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if !canSee(w.players[username], loc) {
+		return Battlefield{}, errors.New("you have no units in or next to that location")
+	}
+	bf := Battlefield{
+		Location: loc,
+		Players:  []Player{},
+		CutOff:   []string{},
+	}
+	present := []string{}
+	for _, username := range w.usernames() {
+		p := playerInLocation(w.players[username], loc)
+		if len(p.Units) == 0 {
+			continue
+		}
+		bf.Players = append(bf.Players, p)
+		present = append(present, username)
+		if slices.Contains(w.cutOff[username], loc) {
+			bf.CutOff = append(bf.CutOff, username)
+		}
+	}
+	bf.Treaties = w.treatiesAmong(present)
+	return bf, nil
+}
+
+// present is every player in the battlefield but the player and the
+// attacker.
+func (bf Battlefield) present(username, attacker string) []Player {
+	players := []Player{}
+	for _, p := range bf.Players {
+		if p.Username != username && p.Username != attacker {
+			players = append(players, p)
+		}
+	}
+	return players
+}
+
+// treaty is the treaty between two players in the battlefield, whichever
+// of them it was recorded for.
+func (bf Battlefield) treaty(username, other string) (Treaty, bool) {
+	for _, treaty := range bf.Treaties[username] {
+		if treaty.With == other {
+			return treaty, true
+		}
+	}
+	for _, treaty := range bf.Treaties[other] {
+		if treaty.With == username {
+			return treaty, true
+		}
+	}
+	return Treaty{}, false
+}
+
+func (bf Battlefield) atPeace(username, other string) bool {
+	_, ok := bf.treaty(username, other)
+	return ok
+}
+
+// warLeader is the player that declares war when attacker moves into the
+// battlefield, so the players there don't each start their own war. It is
+// the first player by username there, the player included, that the server
+// does not know to be at peace with the attacker. Everyone in the
+// battlefield works it out from the same facts, so exactly one of them
+// leads. It is empty if they are all at peace with the attacker.
+func (bf Battlefield) warLeader(username, attacker string) string {
+	candidates := []string{username}
+	for _, p := range bf.present(username, attacker) {
+		candidates = append(candidates, p.Username)
+	}
+	leader := ""
+	for _, candidate := range candidates {
+		if bf.atPeace(candidate, attacker) {
+			continue
+		}
+		if leader == "" || candidate < leader {
+			leader = candidate
+		}
+	}
+	return leader
+}
+
+// alliesIn is every ally of the leader in the battlefield that is not at
+// peace with the attacker, sorted by username.
+func (bf Battlefield) alliesIn(leader, attacker string) []Player {
+	allies := []Player{}
+	for _, p := range bf.present(leader, attacker) {
+		treaty, ok := bf.treaty(leader, p.Username)
+		if ok && treaty.Kind == TreatyAlliance && !bf.atPeace(p.Username, attacker) {
+			allies = append(allies, p)
+		}
+	}
+	return allies
+}
+
+// othersIn is every player in the battlefield that is neither the attacker
+// nor bound to the leader or the attacker by a treaty, sorted by username.
+func (bf Battlefield) othersIn(leader, attacker string) []Player {
+	others := []Player{}
+	for _, p := range bf.present(leader, attacker) {
+		if !bf.atPeace(leader, p.Username) && !bf.atPeace(p.Username, attacker) {
+			others = append(others, p)
+		}
+	}
+	return others
+}
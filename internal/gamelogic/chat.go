@@ -0,0 +1,120 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type ChatChannel string
+
+const (
+	ChatGlobal ChatChannel = "global"
+	ChatDirect ChatChannel = "dm"
+	ChatTeam   ChatChannel = "team"
+)
+
+// ChatMessage is said to everyone in a room, whispered to a single player
+// (To) or said to a team (Team).
+type ChatMessage struct {
+	Username string
+	Room     string
+	Channel  ChatChannel
+	To       string
+	Team     string
+	Message  string
+	SentAt   time.Time
+}
+
+// Route is where a message is published. Everyone in the room reads
+// r1.chat.global off the topic exchange. Whispers and team messages go to the
+// server's r1.chat queue instead, which hands them only to their recipients.
+func (msg ChatMessage) Route() (exchange, key string) {
+	switch msg.Channel {
+	case ChatDirect, ChatTeam:
+		return routing.ExchangeDefault, routing.RoomKey(msg.Room, routing.ChatPrefix)
+	default:
+		return routing.ExchangePerilTopic, routing.RoomKey(msg.Room, routing.ChatPrefix, string(ChatGlobal))
+	}
+}
+
+func (gs *GameState) newChatMessage(channel ChatChannel, words []string) ChatMessage {
+	return ChatMessage{
+		Username: gs.GetUsername(),
+		Room:     gs.GetRoom(),
+		Channel:  channel,
+		Message:  strings.Join(words, " "),
+		SentAt:   time.Now(),
+	}
+}
+
+// CommandSay talks to everyone in the room.
+func (gs *GameState) CommandSay(words []string) (ChatMessage, error) {
+	if len(words) < 2 {
+		return ChatMessage{}, errors.New("usage: say <message>")
+	}
+	return gs.newChatMessage(ChatGlobal, words[1:]), nil
+}
+
+// CommandWhisper talks to a single player.
+func (gs *GameState) CommandWhisper(words []string) (ChatMessage, error) {
+	if len(words) < 3 {
+		return ChatMessage{}, errors.New("usage: whisper <player> <message>")
+	}
+	if words[1] == gs.GetUsername() {
+		return ChatMessage{}, errors.New("error: you can not whisper to yourself")
+	}
+	msg := gs.newChatMessage(ChatDirect, words[2:])
+	msg.To = words[1]
+	return msg, nil
+}
+
+// CommandTeam talks to the player's team. Joining, inviting and leaving go
+// through CommandTeamRequest.
+func (gs *GameState) CommandTeam(words []string) (ChatMessage, error) {
+	if len(words) < 2 {
+		return ChatMessage{}, errors.New("usage: team join <name> | team invite <player> | team leave | team <message>")
+	}
+	team := gs.GetTeam()
+	if team == "" {
+		return ChatMessage{}, errors.New("error: join a team first: team join <name>")
+	}
+	msg := gs.newChatMessage(ChatTeam, words[1:])
+	msg.Team = team
+	return msg, nil
+}
+
+// HandleChat shows a message someone else sent.
+func (gs *GameState) HandleChat(msg ChatMessage) {
+	if msg.Username == gs.GetUsername() {
+		return
+	}
+	fmt.Println()
+	switch msg.Channel {
+	case ChatDirect:
+		fmt.Printf("[%s] %s whispers: %s\n", msg.SentAt.Format(time.Kitchen), msg.Username, msg.Message)
+	case ChatTeam:
+		fmt.Printf("[%s] (team %s) %s: %s\n", msg.SentAt.Format(time.Kitchen), msg.Team, msg.Username, msg.Message)
+	default:
+		fmt.Printf("[%s] %s: %s\n", msg.SentAt.Format(time.Kitchen), msg.Username, msg.Message)
+	}
+}
+
+// ChatLog is how a chat message is written to the game log.
+func ChatLog(msg ChatMessage) routing.GameLog {
+	where := fmt.Sprintf("said in room %s", msg.Room)
+	switch msg.Channel {
+	case ChatTeam:
+		where = fmt.Sprintf("said to team %s in room %s", msg.Team, msg.Room)
+	case ChatDirect:
+		where = fmt.Sprintf("whispered to %s in room %s", msg.To, msg.Room)
+	}
+	return routing.GameLog{
+		CurrentTime: msg.SentAt,
+		Message:     fmt.Sprintf("%s: %s", where, msg.Message),
+		Username:    msg.Username,
+	}
+}
@@ -0,0 +1,396 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"reflect"
+	"sort"
+)
+
+const (
+	maxAttackerDice = 3
+	maxDefenderDice = 2
+	maxBattleRounds = 10
+	// diceHitDamage is what a unit loses for each die it loses on
+	diceHitDamage = 10
+	// survivors of a battle learn from it, the winners more so
+	battleXP  = 1
+	victoryXP = 1
+)
+
+// TerrainModifier multiplies the power of each side fighting in a location.
+type TerrainModifier struct {
+	Attacker float64
+	Defender float64
+}
+
+// CombatConfig holds the rules wars are fought with. Counters maps a rank to
+// the multiplier it gets against each opposing rank, Veterancy a promotion
+// to the multiplier it gives and OutOfSupply is the multiplier of units cut
+// off from home, anything missing is 1.
+type CombatConfig struct {
+	RankPower   map[UnitRank]float64
+	Counters    map[UnitRank]map[UnitRank]float64
+	Terrain     map[Location]TerrainModifier
+	Veterancy   map[UnitVeterancy]float64
+	OutOfSupply float64
+}
+
+// BattleRound lists the units hit in a round, and those of them the hit
+// killed.
+type BattleRound struct {
+	Round          int
+	AttackerRolls  []int
+	DefenderRolls  []int
+	AttackerHits   []int
+	DefenderHits   []int
+	AttackerLosses []int
+	DefenderLosses []int
+}
+
+type BattleReport struct {
+	Model  CombatModel
+	Seed   int64
+	Rounds []BattleRound
+}
+
+type CombatCalculator struct {
+	config CombatConfig
+}
+
+func DefaultCombatConfig() CombatConfig {
+	return CombatConfig{
+		RankPower: map[UnitRank]float64{
+			RankArtillery: 10,
+			RankCavalry:   5,
+			RankInfantry:  1,
+		},
+		Counters: map[UnitRank]map[UnitRank]float64{
+			RankCavalry: {
+				RankArtillery: 1.5,
+				RankInfantry:  0.75,
+			},
+			RankArtillery: {
+				RankInfantry: 1.5,
+				RankCavalry:  0.75,
+			},
+			RankInfantry: {
+				RankCavalry:   1.5,
+				RankArtillery: 0.75,
+			},
+		},
+		Terrain: map[Location]TerrainModifier{
+			"antarctica": {Attacker: 1, Defender: 1.5},
+			"asia":       {Attacker: 1, Defender: 1.25},
+			"australia":  {Attacker: 0.8, Defender: 1},
+		},
+		Veterancy: map[UnitVeterancy]float64{
+			VeterancyVeteran: 1.25,
+			VeterancyElite:   1.5,
+		},
+		OutOfSupply: 0.5,
+	}
+}
+
+// combatConfigFile is a combat config as written in a file, where anything
+// can be left out down to a single counter or terrain side.
+type combatConfigFile struct {
+	RankPower map[UnitRank]float64
+	Counters  map[UnitRank]map[UnitRank]float64
+	Terrain   map[Location]struct {
+		Attacker *float64
+		Defender *float64
+	}
+	Veterancy   map[UnitVeterancy]float64
+	OutOfSupply *float64
+}
+
+// LoadCombatConfig reads a JSON combat config. It is merged into the
+// defaults key by key, so anything the file leaves out keeps its default
+// value.
+func LoadCombatConfig(path string) (CombatConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CombatConfig{}, fmt.Errorf("could not read combat config: %v", err)
+	}
+	file := combatConfigFile{}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return CombatConfig{}, fmt.Errorf("could not parse combat config: %v", err)
+	}
+
+	config := DefaultCombatConfig()
+	for rank, power := range file.RankPower {
+		config.RankPower[rank] = power
+	}
+	for rank, counters := range file.Counters {
+		if config.Counters[rank] == nil {
+			config.Counters[rank] = map[UnitRank]float64{}
+		}
+		for opponent, multiplier := range counters {
+			config.Counters[rank][opponent] = multiplier
+		}
+	}
+	for loc, modifier := range file.Terrain {
+		terrain, ok := config.Terrain[loc]
+		if !ok {
+			terrain = TerrainModifier{Attacker: 1, Defender: 1}
+		}
+		if modifier.Attacker != nil {
+			terrain.Attacker = *modifier.Attacker
+		}
+		if modifier.Defender != nil {
+			terrain.Defender = *modifier.Defender
+		}
+		config.Terrain[loc] = terrain
+	}
+	for veterancy, multiplier := range file.Veterancy {
+		config.Veterancy[veterancy] = multiplier
+	}
+	if file.OutOfSupply != nil {
+		config.OutOfSupply = *file.OutOfSupply
+	}
+	return config, nil
+}
+
+func NewCombatCalculator(config CombatConfig) *CombatCalculator {
+	return &CombatCalculator{
+		config: config,
+	}
+}
+
+func (gs *GameState) CommandCombat(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: combat <power|dice> [rules.json]")
+	}
+	model := CombatModel(words[1])
+	if _, ok := getAllCombatModels()[model]; !ok {
+		return fmt.Errorf("error: %s is not a valid combat model", model)
+	}
+	if len(words) > 2 {
+		rules, err := LoadCombatConfig(words[2])
+		if err != nil {
+			return err
+		}
+		gs.setCombatRules(rules)
+		fmt.Printf("Loaded combat rules from %s\n", words[2])
+	}
+	gs.setCombatModel(model)
+	fmt.Printf("Wars will be fought with the %s combat model when your opponent chose it too\n", model)
+	return nil
+}
+
+// agreedCombatRules is what a war is fought with. The attacker's model and
+// rules only count if the player chose the same ones, otherwise neither side
+// gets to pick and the defaults apply.
+func (gs *GameState) agreedCombatRules(model CombatModel, rules CombatConfig) (CombatModel, CombatConfig) {
+	if model == gs.getCombatModel() && reflect.DeepEqual(rules, gs.getCombatRules()) {
+		return model, rules
+	}
+	return CombatModelPower, DefaultCombatConfig()
+}
+
+// acceptsCombatRules reports whether the player agrees to fight a war with
+// model and rules, which it only does with its own or the defaults.
+func (gs *GameState) acceptsCombatRules(model CombatModel, rules CombatConfig) bool {
+	agreedModel, agreedRules := gs.agreedCombatRules(model, rules)
+	if agreedModel == model && reflect.DeepEqual(agreedRules, rules) {
+		return true
+	}
+	return model == CombatModelPower && reflect.DeepEqual(rules, DefaultCombatConfig())
+}
+
+// Power is the strength of units fighting opponents in a location. Each
+// unit's base power is scaled by its promotion, its average counter
+// multiplier against the opposing units and by the terrain modifier for its
+// side.
+func (c *CombatCalculator) Power(units, opponents []Unit, loc Location, defending bool) float64 {
+	return c.power(units, nil, opponents, loc, defending)
+}
+
+// power is Power with the units whose IDs are in cutOff fighting out of
+// supply. Supply comes from the server's recognition, never from the units.
+func (c *CombatCalculator) power(units []Unit, cutOff map[int]bool, opponents []Unit, loc Location, defending bool) float64 {
+	power := 0.0
+	for _, unit := range units {
+		power += c.unitPower(unit.Rank) * c.veterancy(unit.Veterancy()) * c.supply(cutOff[unit.ID]) * c.counterAgainst(unit.Rank, opponents)
+	}
+	return power * c.terrain(loc, defending)
+}
+
+func (c *CombatCalculator) supply(cutOff bool) float64 {
+	if !cutOff || c.config.OutOfSupply == 0 {
+		return 1
+	}
+	return c.config.OutOfSupply
+}
+
+func (c *CombatCalculator) veterancy(v UnitVeterancy) float64 {
+	multiplier, ok := c.config.Veterancy[v]
+	if !ok {
+		return 1
+	}
+	return multiplier
+}
+
+func (c *CombatCalculator) unitPower(rank UnitRank) float64 {
+	return c.config.RankPower[rank]
+}
+
+func (c *CombatCalculator) counter(rank, opponent UnitRank) float64 {
+	multiplier, ok := c.config.Counters[rank][opponent]
+	if !ok {
+		return 1
+	}
+	return multiplier
+}
+
+func (c *CombatCalculator) counterAgainst(rank UnitRank, opponents []Unit) float64 {
+	if len(opponents) == 0 {
+		return 1
+	}
+	total := 0.0
+	for _, opponent := range opponents {
+		total += c.counter(rank, opponent.Rank)
+	}
+	return total / float64(len(opponents))
+}
+
+func (c *CombatCalculator) terrain(loc Location, defending bool) float64 {
+	modifier, ok := c.config.Terrain[loc]
+	if !ok {
+		return 1
+	}
+	if defending {
+		return modifier.Defender
+	}
+	return modifier.Attacker
+}
+
+// fightDiceBattle plays Risk-style rounds until one side is wiped out or
+// maxBattleRounds is reached. Each round the attacker rolls up to three dice
+// and the defender up to two, the highest dice are compared pairwise and the
+// defender wins ties. Rolls are scaled by terrain and by the counter between
+// the two weakest units facing each other, and the loser of each pair has
+// its weakest unit hit for diceHitDamage. A unit is lost once it runs out
+// of HP.
+func (c *CombatCalculator) fightDiceBattle(seed int64, loc Location, attackers, defenders []Unit) (report BattleReport, attackerLosses, defenderLosses []int) {
+	rng := rand.New(rand.NewSource(seed))
+	attackers = c.weakestFirst(attackers)
+	defenders = c.weakestFirst(defenders)
+	hp := map[bool]map[int]int{false: {}, true: {}}
+	for _, unit := range attackers {
+		hp[false][unit.ID] = unit.health()
+	}
+	for _, unit := range defenders {
+		hp[true][unit.ID] = unit.health()
+	}
+	report = BattleReport{
+		Model: CombatModelDice,
+		Seed:  seed,
+	}
+
+	for round := 1; round <= maxBattleRounds && len(attackers) > 0 && len(defenders) > 0; round++ {
+		br := BattleRound{
+			Round:         round,
+			AttackerRolls: rollDice(rng, min(maxAttackerDice, len(attackers))),
+			DefenderRolls: rollDice(rng, min(maxDefenderDice, len(defenders))),
+		}
+		for i := 0; i < len(br.AttackerRolls) && i < len(br.DefenderRolls); i++ {
+			attack := float64(br.AttackerRolls[i]) * c.terrain(loc, false) * c.counter(attackers[0].Rank, defenders[0].Rank)
+			defense := float64(br.DefenderRolls[i]) * c.terrain(loc, true) * c.counter(defenders[0].Rank, attackers[0].Rank)
+			if attack > defense {
+				id := defenders[0].ID
+				br.DefenderHits = append(br.DefenderHits, id)
+				hp[true][id] -= diceHitDamage
+				if hp[true][id] <= 0 {
+					br.DefenderLosses = append(br.DefenderLosses, id)
+					defenders = defenders[1:]
+				}
+			} else {
+				id := attackers[0].ID
+				br.AttackerHits = append(br.AttackerHits, id)
+				hp[false][id] -= diceHitDamage
+				if hp[false][id] <= 0 {
+					br.AttackerLosses = append(br.AttackerLosses, id)
+					attackers = attackers[1:]
+				}
+			}
+		}
+		attackerLosses = append(attackerLosses, br.AttackerLosses...)
+		defenderLosses = append(defenderLosses, br.DefenderLosses...)
+		report.Rounds = append(report.Rounds, br)
+	}
+	return report, attackerLosses, defenderLosses
+}
+
+// diceDamage adds up the HP each unit lost to the hits of a dice battle.
+func diceDamage(report BattleReport, defending bool) map[int]int {
+	damage := map[int]int{}
+	for _, round := range report.Rounds {
+		hits := round.AttackerHits
+		if defending {
+			hits = round.DefenderHits
+		}
+		for _, id := range hits {
+			damage[id] += diceHitDamage
+		}
+	}
+	return damage
+}
+
+// spreadDamage has the units soak up damage weakest first. The last unit
+// standing is never killed by it, so the side that won a battle keeps the
+// field.
+func (c *CombatCalculator) spreadDamage(units []Unit, damage int) map[int]int {
+	wounds := map[int]int{}
+	sorted := c.weakestFirst(units)
+	for i, unit := range sorted {
+		if damage <= 0 {
+			break
+		}
+		wound := min(damage, unit.health())
+		if i == len(sorted)-1 {
+			wound = min(wound, unit.health()-1)
+		}
+		wounds[unit.ID] = wound
+		damage -= wound
+	}
+	return wounds
+}
+
+func rollDice(rng *rand.Rand, n int) []int {
+	rolls := make([]int, n)
+	for i := range rolls {
+		rolls[i] = rng.Intn(6) + 1
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(rolls)))
+	return rolls
+}
+
+// weakestFirst orders units so the cheapest ones are lost first.
+func (c *CombatCalculator) weakestFirst(units []Unit) []Unit {
+	sorted := append([]Unit{}, units...)
+	sort.Slice(sorted, func(i, j int) bool {
+		pi, pj := c.unitPower(sorted[i].Rank), c.unitPower(sorted[j].Rank)
+		if pi != pj {
+			return pi < pj
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return sorted
+}
+
+func printBattleReport(report BattleReport) {
+	if len(report.Rounds) == 0 {
+		return
+	}
+	fmt.Printf("Battle report (%s, seed %v):\n", report.Model, report.Seed)
+	for _, round := range report.Rounds {
+		fmt.Printf("  Round %v: attacker rolled %v, defender rolled %v", round.Round, round.AttackerRolls, round.DefenderRolls)
+		fmt.Printf(" -> attacker lost %v, defender lost %v\n", len(round.AttackerLosses), len(round.DefenderLosses))
+	}
+}
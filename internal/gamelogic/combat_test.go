@@ -0,0 +1,333 @@
+package gamelogic
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func neutralCombatConfig() CombatConfig {
+	return CombatConfig{
+		RankPower: DefaultCombatConfig().RankPower,
+	}
+}
+
+func TestPowerMatchesRankPowerWithoutModifiers(t *testing.T) {
+	calc := NewCombatCalculator(neutralCombatConfig())
+	units := []Unit{
+		{ID: 1, Rank: RankArtillery},
+		{ID: 2, Rank: RankCavalry},
+		{ID: 3, Rank: RankInfantry},
+	}
+	opponents := []Unit{{ID: 1, Rank: RankInfantry}}
+
+	got := calc.Power(units, opponents, "europe", false)
+	if got != 16 {
+		t.Errorf("expected power 16, got %v", got)
+	}
+}
+
+func TestPowerAppliesCounters(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	cavalry := []Unit{{ID: 1, Rank: RankCavalry}}
+
+	cases := []struct {
+		opponent UnitRank
+		want     float64
+	}{
+		{RankArtillery, 7.5},
+		{RankInfantry, 3.75},
+		{RankCavalry, 5},
+	}
+	for _, c := range cases {
+		got := calc.Power(cavalry, []Unit{{ID: 1, Rank: c.opponent}}, "europe", false)
+		if got != c.want {
+			t.Errorf("cavalry against %s: expected power %v, got %v", c.opponent, c.want, got)
+		}
+	}
+}
+
+func TestPowerAveragesCountersOverOpponents(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	cavalry := []Unit{{ID: 1, Rank: RankCavalry}}
+	opponents := []Unit{
+		{ID: 1, Rank: RankArtillery},
+		{ID: 2, Rank: RankInfantry},
+	}
+
+	got := calc.Power(cavalry, opponents, "europe", false)
+	if got != 5*(1.5+0.75)/2 {
+		t.Errorf("expected power %v, got %v", 5*(1.5+0.75)/2, got)
+	}
+}
+
+func TestPowerAppliesTerrain(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	infantry := []Unit{{ID: 1, Rank: RankInfantry}}
+
+	if got := calc.Power(infantry, infantry, "antarctica", true); got != 1.5 {
+		t.Errorf("expected defender power 1.5 in antarctica, got %v", got)
+	}
+	if got := calc.Power(infantry, infantry, "antarctica", false); got != 1 {
+		t.Errorf("expected attacker power 1 in antarctica, got %v", got)
+	}
+	if got := calc.Power(infantry, infantry, "australia", false); got != 0.8 {
+		t.Errorf("expected attacker power 0.8 in australia, got %v", got)
+	}
+}
+
+func TestWeakestFirst(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	units := []Unit{
+		{ID: 1, Rank: RankArtillery},
+		{ID: 4, Rank: RankInfantry},
+		{ID: 2, Rank: RankCavalry},
+		{ID: 3, Rank: RankInfantry},
+	}
+
+	got := unitIDs(calc.weakestFirst(units))
+	want := []int{3, 4, 2, 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected order %v, got %v", want, got)
+	}
+}
+
+func TestDiceBattleIsDeterministic(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	attackers := []Unit{
+		{ID: 1, Rank: RankInfantry},
+		{ID: 2, Rank: RankInfantry},
+		{ID: 3, Rank: RankCavalry},
+	}
+	defenders := []Unit{
+		{ID: 1, Rank: RankInfantry},
+		{ID: 2, Rank: RankArtillery},
+	}
+
+	report1, attackerLosses1, defenderLosses1 := calc.fightDiceBattle(42, "europe", attackers, defenders)
+	report2, attackerLosses2, defenderLosses2 := calc.fightDiceBattle(42, "europe", attackers, defenders)
+	if !reflect.DeepEqual(report1, report2) {
+		t.Errorf("expected identical reports for the same seed")
+	}
+	if !reflect.DeepEqual(attackerLosses1, attackerLosses2) || !reflect.DeepEqual(defenderLosses1, defenderLosses2) {
+		t.Errorf("expected identical casualties for the same seed")
+	}
+}
+
+func TestDiceBattleEndsWhenASideIsWipedOut(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	attackers := []Unit{{ID: 1, Rank: RankInfantry}}
+	defenders := []Unit{{ID: 1, Rank: RankInfantry}}
+
+	for seed := int64(0); seed < 20; seed++ {
+		report, attackerLosses, defenderLosses := calc.fightDiceBattle(seed, "europe", attackers, defenders)
+		if len(report.Rounds) != 1 {
+			t.Fatalf("seed %v: expected a single round, got %v", seed, len(report.Rounds))
+		}
+		if len(attackerLosses)+len(defenderLosses) != 1 {
+			t.Fatalf("seed %v: expected exactly one casualty, got %v and %v", seed, attackerLosses, defenderLosses)
+		}
+	}
+}
+
+func TestResolveWarPowerModel(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+				2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+			},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+				2: {ID: 2, Rank: RankInfantry, Location: "europe"},
+			},
+		},
+		Model: CombatModelPower,
+		Rules: DefaultCombatConfig(),
+	}
+
+	res, ok := resolveWar(rw)
+	if !ok {
+		t.Fatal("expected the war to be fought")
+	}
+	if res.Location != "europe" {
+		t.Errorf("expected the war to be fought in europe, got %s", res.Location)
+	}
+	if res.Winner != "attacker" || !reflect.DeepEqual(res.Losers, []string{"defender"}) {
+		t.Errorf("expected attacker to win, got winner %q losers %v", res.Winner, res.Losers)
+	}
+	want := map[string][]int{"defender": {1, 2}}
+	if !reflect.DeepEqual(res.Casualties, want) {
+		t.Errorf("expected casualties %v, got %v", want, res.Casualties)
+	}
+}
+
+func TestResolveWarTerrainFavorsDefender(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "antarctica"}},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "antarctica"}},
+		},
+		Model: CombatModelPower,
+		Rules: DefaultCombatConfig(),
+	}
+
+	res, _ := resolveWar(rw)
+	if res.Winner != "defender" {
+		t.Errorf("expected defender to win in antarctica, got %q", res.Winner)
+	}
+}
+
+func TestResolveWarWithoutOverlap(t *testing.T) {
+	rw := RecognitionOfWar{
+		Attacker: Player{
+			Username: "attacker",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "asia"}},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+		},
+	}
+
+	if _, ok := resolveWar(rw); ok {
+		t.Error("expected no war without units in the same location")
+	}
+}
+
+func TestResolveWarFreeForAll(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+				2: {ID: 2, Rank: RankArtillery, Location: "europe"},
+			},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+		},
+		Others: []Player{{
+			Username: "other",
+			Units:    map[int]Unit{3: {ID: 3, Rank: RankInfantry, Location: "europe"}},
+		}},
+		Model: CombatModelPower,
+		Rules: DefaultCombatConfig(),
+	}
+
+	res, ok := resolveWar(rw)
+	if !ok {
+		t.Fatal("expected the war to be fought")
+	}
+	if len(res.Battles) != 2 {
+		t.Fatalf("expected the attacker to fight both sides, got %v battles", len(res.Battles))
+	}
+	if res.Battles[1].Attacker != "attacker" || res.Battles[1].Defender != "other" {
+		t.Errorf("expected the holder of the battlefield to fight other, got %s against %s", res.Battles[1].Attacker, res.Battles[1].Defender)
+	}
+	if res.Winner != "attacker" || !reflect.DeepEqual(res.Losers, []string{"defender", "other"}) {
+		t.Errorf("expected attacker to win against everyone, got winner %q losers %v", res.Winner, res.Losers)
+	}
+	want := map[string][]int{"defender": {1}, "other": {3}}
+	if !reflect.DeepEqual(res.Casualties, want) {
+		t.Errorf("expected casualties %v, got %v", want, res.Casualties)
+	}
+}
+
+func TestResolveWarDiceIsDeterministicWithManySides(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankCavalry, Location: "asia"},
+				2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+			},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankArtillery, Location: "asia"}},
+		},
+		Others: []Player{{
+			Username: "other",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "asia"}},
+		}},
+		Model: CombatModelDice,
+		Rules: DefaultCombatConfig(),
+		Seed:  7,
+	}
+
+	first, _ := resolveWar(rw)
+	second, _ := resolveWar(rw)
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same resolution twice, got %v and %v", first, second)
+	}
+}
+
+func TestLoadCombatConfigMergesIntoDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	data := `{"Counters": {"cavalry": {"infantry": 2}}, "Terrain": {"asia": {"Attacker": 0.5}, "europe": {"Defender": 2}}}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := LoadCombatConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := DefaultCombatConfig()
+	want.Counters[RankCavalry][RankInfantry] = 2
+	want.Terrain["asia"] = TerrainModifier{Attacker: 0.5, Defender: 1.25}
+	want.Terrain["europe"] = TerrainModifier{Attacker: 1, Defender: 2}
+	if !reflect.DeepEqual(config, want) {
+		t.Errorf("expected the file merged into the defaults\nwant %+v\n got %+v", want, config)
+	}
+}
+
+func TestCombatRulesNeedBothSides(t *testing.T) {
+	attacker := Player{
+		Username: "attacker",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+	bf := Battlefield{Location: "europe", Players: []Player{attacker}}
+	custom := DefaultCombatConfig()
+	custom.RankPower[RankInfantry] = 100
+
+	defender := NewGameState("defender")
+	defender.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	defender.setCombatModel(CombatModelDice)
+	defender.setCombatRules(custom)
+
+	// the attacker sticks to the defaults, so the defender's choice is ignored
+	rw, _ := defender.NewRecognitionOfWar(ArmyMove{Player: attacker, Model: CombatModelPower, Rules: DefaultCombatConfig()}, bf)
+	if rw.Model != CombatModelPower || !reflect.DeepEqual(rw.Rules, DefaultCombatConfig()) {
+		t.Errorf("expected the defaults, got %v %+v", rw.Model, rw.Rules)
+	}
+
+	rw, _ = defender.NewRecognitionOfWar(ArmyMove{Player: attacker, Model: CombatModelDice, Rules: custom}, bf)
+	if rw.Model != CombatModelDice || !reflect.DeepEqual(rw.Rules, custom) {
+		t.Errorf("expected the rules both sides chose, got %v %+v", rw.Model, rw.Rules)
+	}
+
+	// a defender that makes up rules does not get them past the attacker
+	gs := NewGameState("attacker")
+	if gs.acceptsCombatRules(CombatModelDice, custom) {
+		t.Error("expected the attacker to refuse rules it did not choose")
+	}
+	if !gs.acceptsCombatRules(CombatModelPower, DefaultCombatConfig()) {
+		t.Error("expected the attacker to accept the defaults")
+	}
+}
@@ -0,0 +1,381 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type WarChoice string
+
+const (
+	WarChoiceFight     = "fight"
+	WarChoiceRetreat   = "retreat"
+	WarChoiceSurrender = "surrender"
+	WarChoiceReinforce = "reinforce"
+)
+
+const (
+	// WarDecisionWindow is how long everyone attacked has to decide what to
+	// do before the war is fought.
+	WarDecisionWindow = 15 * time.Second
+	// WarDecisionGrace is how long the attacker waits past the window for
+	// decisions still on their way.
+	WarDecisionGrace = 2 * time.Second
+	// ResolutionHoldTime is how long a resolution waits for the recognition
+	// it resolves before it is dropped unverified.
+	ResolutionHoldTime = WarDecisionWindow
+)
+
+// WarDecision is sent to the attacker by a player caught in a war. To is
+// where a retreat goes, Units are the units a reinforcement brings into the
+// battlefield, as they were where they came from.
+type WarDecision struct {
+	WarID    string
+	Attacker string
+	Username string
+	Choice   WarChoice
+	To       Location
+	Units    []Unit
+}
+
+// openWar is a war the attacker is waiting to fight until everyone attacked
+// decided or the window closed.
+type openWar struct {
+	rw        RecognitionOfWar
+	decisions map[string]WarDecision
+}
+
+// CommandDecide answers the war awaiting the player's decision:
+// fight, retreat <location>, surrender or reinforce <unitID> <unitID>...
+// Nothing moves until the attacker's resolution shows it accepted the
+// decision.
+func (gs *GameState) CommandDecide(words []string) (WarDecision, error) {
+	rw, ok := gs.popUndecidedWar()
+	if !ok {
+		return WarDecision{}, errors.New("error: no war is waiting on your decision")
+	}
+	d := WarDecision{
+		WarID:    rw.ID,
+		Attacker: rw.Attacker.Username,
+		Username: gs.GetUsername(),
+		Choice:   WarChoice(words[0]),
+	}
+	undo := func(err error) (WarDecision, error) {
+		gs.addUndecidedWar(rw)
+		return WarDecision{}, err
+	}
+	adjacent := getAdjacentLocations()[rw.Location]
+
+	switch words[0] {
+	case WarChoiceFight:
+		fmt.Printf("Your units in %s stand their ground.\n", rw.Location)
+	case WarChoiceRetreat:
+		if len(words) < 2 {
+			return undo(errors.New("usage: retreat <location>"))
+		}
+		d.To = Location(words[1])
+		if !slices.Contains(adjacent, d.To) {
+			return undo(fmt.Errorf("error: you can only retreat to a location next to %s: %v", rw.Location, adjacent))
+		}
+		fmt.Printf("Your units in %s retreat to %s once %s fights the war.\n", rw.Location, d.To, d.Attacker)
+	case WarChoiceSurrender:
+		fmt.Printf("Your units in %s surrender once %s fights the war.\n", rw.Location, d.Attacker)
+	case WarChoiceReinforce:
+		if len(words) < 2 {
+			return undo(errors.New("usage: reinforce <unitID> <unitID>..."))
+		}
+		for _, word := range words[1:] {
+			id, err := strconv.Atoi(word)
+			if err != nil {
+				return undo(fmt.Errorf("error: %s is not a valid unit ID", word))
+			}
+			unit, ok := gs.GetUnit(id)
+			if !ok {
+				return undo(fmt.Errorf("error: unit with ID %v not found", id))
+			}
+			if !slices.Contains(adjacent, unit.Location) {
+				return undo(fmt.Errorf("error: unit %v in %s is too far away to reinforce %s", id, unit.Location, rw.Location))
+			}
+			d.Units = append(d.Units, unit)
+		}
+		fmt.Printf("%v unit(s) reinforce %s once %s fights the war.\n", len(d.Units), rw.Location, d.Attacker)
+	default:
+		return undo(fmt.Errorf("error: %s is not a valid war decision", words[0]))
+	}
+	gs.addSentDecision(d)
+	return d, nil
+}
+
+// applyAcceptedDecision carries out the decision the player sent on a war,
+// as far as the attacker's resolution accepted it. Reinforcements that died
+// in the war are already gone. A retreat or reinforcement returns the move
+// of its units, so the server and everyone watching see them arrive.
+func (gs *GameState) applyAcceptedDecision(res WarResolution) ArmyMove {
+	sent, ok := gs.popSentDecision(res.WarID)
+	if !ok {
+		return ArmyMove{}
+	}
+	i := slices.IndexFunc(res.Decisions, func(d WarDecision) bool { return d.Username == sent.Username })
+	if i < 0 || res.Decisions[i].Choice != sent.Choice || res.Decisions[i].To != sent.To {
+		fmt.Printf("Your decision did not reach %s in time, your units in %s fought.\n", res.Attacker, res.Location)
+		return ArmyMove{}
+	}
+	accepted := res.Decisions[i]
+
+	mv := ArmyMove{
+		Model: gs.getCombatModel(),
+		Rules: gs.getCombatRules(),
+	}
+	switch sent.Choice {
+	case WarChoiceRetreat:
+		mv.ToLocation = sent.To
+		for _, unit := range unitsInLocation(gs.GetPlayerSnap(), res.Location) {
+			unit.Location = sent.To
+			gs.UpdateUnit(unit)
+			mv.Units = append(mv.Units, unit)
+		}
+		fmt.Printf("%v unit(s) retreat from %s to %s.\n", len(mv.Units), res.Location, sent.To)
+	case WarChoiceSurrender:
+		units := unitsInLocation(gs.GetPlayerSnap(), res.Location)
+		gs.removeUnits(unitIDs(units))
+		fmt.Printf("Your %v unit(s) in %s surrender and are taken prisoner.\n", len(units), res.Location)
+	case WarChoiceReinforce:
+		mv.ToLocation = res.Location
+		for _, id := range unitIDs(accepted.Units) {
+			unit, ok := gs.GetUnit(id)
+			if !ok || !slices.Contains(unitIDs(sent.Units), id) {
+				continue
+			}
+			unit.Location = res.Location
+			gs.UpdateUnit(unit)
+			mv.Units = append(mv.Units, unit)
+		}
+		fmt.Printf("%v unit(s) rushed to reinforce %s.\n", len(mv.Units), res.Location)
+	}
+	if len(mv.Units) == 0 {
+		return ArmyMove{}
+	}
+	mv.Player = playerInLocation(gs.GetPlayerSnap(), mv.ToLocation)
+	return mv
+}
+
+// HandleWarDecision records a decision on a war the player is attacking in.
+// Once everyone attacked decided the war is fought and ok is true.
+func (gs *GameState) HandleWarDecision(d WarDecision) (res WarResolution, ok bool) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	gs.mu.Lock()
+	ow, open := gs.openWars[d.WarID]
+	if !open || !slices.Contains(ow.rw.Participants()[1:], d.Username) {
+		gs.mu.Unlock()
+		fmt.Printf("%s decided on a war you are not fighting.\n", d.Username)
+		return WarResolution{}, false
+	}
+	if d.Choice == WarChoiceReinforce {
+		known := gs.knownReinforcements(ow.rw, d)
+		if len(known) < len(d.Units) {
+			fmt.Printf("%s claims %v reinforcement(s) you never saw next to %s, they are turned away.\n", d.Username, len(d.Units)-len(known), ow.rw.Location)
+		}
+		d.Units = known
+	}
+	ow.decisions[d.Username] = d
+	decided := len(ow.decisions) == len(ow.rw.Participants())-1
+	gs.mu.Unlock()
+
+	printWarDecision(d)
+	if !decided {
+		return WarResolution{}, false
+	}
+	return gs.CloseWar(d.WarID)
+}
+
+// CloseWar fights an open war with whatever decisions arrived. ok is false
+// if the war was already fought.
+func (gs *GameState) CloseWar(id string) (WarResolution, bool) {
+	gs.mu.Lock()
+	ow, ok := gs.openWars[id]
+	delete(gs.openWars, id)
+	gs.mu.Unlock()
+	if !ok {
+		return WarResolution{}, false
+	}
+
+	decisions := []WarDecision{}
+	for _, d := range ow.decisions {
+		decisions = append(decisions, d)
+	}
+	sort.Slice(decisions, func(i, j int) bool { return decisions[i].Username < decisions[j].Username })
+	res, ok := resolveWarWithDecisions(ow.rw, decisions)
+	if !ok {
+		return WarResolution{}, false
+	}
+
+	fought := applyWarDecisions(ow.rw, decisions)
+	for _, p := range fought.players()[1:] {
+		gs.recordSighting(p, res.Location)
+	}
+	fmt.Printf("The war in %s is fought!\n", res.Location)
+	for _, p := range fought.players() {
+		fmt.Printf("%s's units:\n", p.Username)
+		for _, unit := range unitsInLocation(p, res.Location) {
+			fmt.Printf("  * %v\n", unit.Title())
+		}
+	}
+	return res, true
+}
+
+// knownReinforcements keeps the reinforcements the attacker saw next to the
+// battlefield, as strong as it last saw them at most, so nobody can invent
+// units or heal them on the way. It must be called with the lock held.
+func (gs *GameState) knownReinforcements(rw RecognitionOfWar, d WarDecision) []Unit {
+	adjacent := getAdjacentLocations()[rw.Location]
+	known := []Unit{}
+	for _, unit := range d.Units {
+		if !slices.Contains(adjacent, unit.Location) {
+			continue
+		}
+		for _, seen := range gs.intel[d.Username][unit.Location].Units {
+			if seen.ID != unit.ID || seen.Rank != unit.Rank {
+				continue
+			}
+			unit.HP = min(unit.health(), seen.health())
+			unit.XP = min(unit.XP, seen.XP)
+			known = append(known, unit)
+			break
+		}
+	}
+	return known
+}
+
+func printWarDecision(d WarDecision) {
+	switch d.Choice {
+	case WarChoiceRetreat:
+		fmt.Printf("%s retreats to %s.\n", d.Username, d.To)
+	case WarChoiceSurrender:
+		fmt.Printf("%s surrenders!\n", d.Username)
+	case WarChoiceReinforce:
+		fmt.Printf("%s brings %v unit(s) of reinforcements.\n", d.Username, len(d.Units))
+	default:
+		fmt.Printf("%s stands its ground.\n", d.Username)
+	}
+}
+
+// resolveWarWithDecisions fights the war as it stands after everyone's
+// decisions. The attacker and everyone checking its result call it with the
+// same decisions, so they all get the same resolution.
+func resolveWarWithDecisions(rw RecognitionOfWar, decisions []WarDecision) (WarResolution, bool) {
+	res, ok := resolveWar(applyWarDecisions(rw, decisions))
+	if !ok {
+		return WarResolution{}, false
+	}
+	if len(decisions) > 0 {
+		res.Decisions = decisions
+	}
+	return res, true
+}
+
+// applyWarDecisions returns the recognition with retreated and surrendered
+// units gone from the battlefield and reinforcements in it. Only units that
+// come from next to the battlefield and are not already fighting in it can
+// reinforce it.
+func applyWarDecisions(rw RecognitionOfWar, decisions []WarDecision) RecognitionOfWar {
+	apply := func(p Player) Player {
+		for _, d := range decisions {
+			if d.Username != p.Username {
+				continue
+			}
+			units := map[int]Unit{}
+			for id, unit := range p.Units {
+				if d.Choice == WarChoiceFight || d.Choice == WarChoiceReinforce || unit.Location != rw.Location {
+					units[id] = unit
+				}
+			}
+			adjacent := getAdjacentLocations()[rw.Location]
+			for _, unit := range d.Units {
+				if d.Choice != WarChoiceReinforce || !slices.Contains(adjacent, unit.Location) {
+					continue
+				}
+				if fighting, ok := units[unit.ID]; ok && fighting.Location == rw.Location {
+					continue
+				}
+				unit.Location = rw.Location
+				units[unit.ID] = unit
+			}
+			p.Units = units
+		}
+		return p
+	}
+
+	rw.Defender = apply(rw.Defender)
+	rw.Allies = append([]Player{}, rw.Allies...)
+	for i := range rw.Allies {
+		rw.Allies[i] = apply(rw.Allies[i])
+	}
+	rw.Others = append([]Player{}, rw.Others...)
+	for i := range rw.Others {
+		rw.Others[i] = apply(rw.Others[i])
+	}
+	return rw
+}
+
+func (gs *GameState) addOpenWar(rw RecognitionOfWar) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.openWars[rw.ID] = openWar{
+		rw:        rw,
+		decisions: map[string]WarDecision{},
+	}
+}
+
+func (gs *GameState) addSentDecision(d WarDecision) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.sentDecisions[d.WarID] = d
+}
+
+func (gs *GameState) popSentDecision(id string) (WarDecision, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	d, ok := gs.sentDecisions[id]
+	delete(gs.sentDecisions, id)
+	return d, ok
+}
+
+func (gs *GameState) addUndecidedWar(rw RecognitionOfWar) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.undecidedWars[rw.ID] = rw
+}
+
+// forgetUndecidedWar stops waiting on the player's decision for a war that
+// was already fought.
+func (gs *GameState) forgetUndecidedWar(id string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.undecidedWars, id)
+}
+
+// popUndecidedWar returns the war whose decision window closes first,
+// forgetting any whose window already closed.
+func (gs *GameState) popUndecidedWar() (RecognitionOfWar, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	var next RecognitionOfWar
+	found := false
+	for id, rw := range gs.undecidedWars {
+		if time.Now().After(rw.DecideBy) {
+			delete(gs.undecidedWars, id)
+			continue
+		}
+		if !found || rw.DecideBy.Before(next.DecideBy) {
+			next = rw
+			found = true
+		}
+	}
+	delete(gs.undecidedWars, next.ID)
+	return next, found
+}
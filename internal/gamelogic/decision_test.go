@@ -0,0 +1,267 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecidingOnARecognizedWar(t *testing.T) {
+	gs := NewGameState("defender")
+	if err := gs.CommandSpawn([]string{"spawn", "europe", "infantry"}); err != nil {
+		t.Fatal(err)
+	}
+	attacker := Player{
+		Username: "attacker",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+
+	rw, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker}})
+	if !ok {
+		t.Fatal("expected the only defender to declare the war")
+	}
+	if rw.Location != "europe" {
+		t.Errorf("expected the war to be in europe, got %q", rw.Location)
+	}
+	if !rw.DecideBy.After(time.Now()) {
+		t.Errorf("expected the decision window to still be open, it closed at %v", rw.DecideBy)
+	}
+	if got := gs.HandleWar(rw); got != WarOutcomeAwaitingResolution {
+		t.Fatalf("expected the defender to await the resolution, got %v", got)
+	}
+
+	d, err := gs.CommandDecide([]string{"retreat", "asia"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.WarID != rw.ID || d.Choice != WarChoiceRetreat || d.To != "asia" {
+		t.Errorf("expected a retreat to asia from %s, got %+v", rw.ID, d)
+	}
+	if unit, _ := gs.GetUnit(1); unit.Location != "europe" {
+		t.Errorf("expected the unit to wait for the attacker in europe, it is in %s", unit.Location)
+	}
+	if _, err := gs.CommandDecide([]string{"fight"}); err == nil {
+		t.Error("expected no war to be left to decide on")
+	}
+
+	res, _ := resolveWarWithDecisions(rw, []WarDecision{d})
+	_, _, mv := gs.HandleWarResolution(res)
+	if unit, _ := gs.GetUnit(1); unit.Location != "asia" {
+		t.Errorf("expected the unit to have retreated to asia, it is in %s", unit.Location)
+	}
+	if mv.ToLocation != "asia" || len(mv.Units) != 1 || len(mv.Player.Units) != 1 {
+		t.Errorf("expected the retreat to move one unit to asia, got %+v", mv)
+	}
+}
+
+func TestDecisionTheAttackerMissedIsNotApplied(t *testing.T) {
+	gs := NewGameState("defender")
+	if err := gs.CommandSpawn([]string{"spawn", "europe", "artillery"}); err != nil {
+		t.Fatal(err)
+	}
+	attacker := Player{
+		Username: "attacker",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+	rw, _ := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker}})
+	gs.HandleWar(rw)
+	if _, err := gs.CommandDecide([]string{"surrender"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gs.GetUnit(1); !ok {
+		t.Fatal("expected the unit to wait for the attacker before surrendering")
+	}
+
+	// the surrender arrived after the war was fought
+	res, _ := resolveWarWithDecisions(rw, nil)
+	if _, _, mv := gs.HandleWarResolution(res); len(mv.Units) != 0 {
+		t.Errorf("expected nothing to move, got %+v", mv)
+	}
+	if _, ok := gs.GetUnit(1); !ok {
+		t.Error("expected the unit that fought and won to still be there")
+	}
+}
+
+func TestAcceptedReinforcementsMove(t *testing.T) {
+	gs := NewGameState("defender")
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	gs.addUnit(Unit{ID: 2, Rank: RankArtillery, Location: "asia"})
+	gs.addUnit(Unit{ID: 3, Rank: RankArtillery, Location: "asia"})
+	attacker := Player{
+		Username: "attacker",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+	rw, _ := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker}})
+	gs.HandleWar(rw)
+	d, err := gs.CommandDecide([]string{"reinforce", "2", "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unit, _ := gs.GetUnit(2); unit.Location != "asia" {
+		t.Fatalf("expected the reinforcement to wait for the attacker in asia, it is in %s", unit.Location)
+	}
+
+	// the attacker only let unit 2 through
+	d.Units = d.Units[:1]
+	res, _ := resolveWarWithDecisions(rw, []WarDecision{d})
+	_, _, mv := gs.HandleWarResolution(res)
+	if unit, _ := gs.GetUnit(2); unit.Location != "europe" {
+		t.Errorf("expected the accepted reinforcement in europe, it is in %s", unit.Location)
+	}
+	if unit, _ := gs.GetUnit(3); unit.Location != "asia" {
+		t.Errorf("expected the turned away unit to stay in asia, it is in %s", unit.Location)
+	}
+	if mv.ToLocation != "europe" || !reflect.DeepEqual(unitIDs(mv.Units), []int{2}) {
+		t.Errorf("expected the move of unit 2 into europe, got %+v", mv)
+	}
+}
+
+func TestAttackerOnlyLetsKnownUnitsReinforce(t *testing.T) {
+	gs := NewGameState("attacker")
+	gs.addUnit(Unit{ID: 1, Rank: RankCavalry, Location: "europe"})
+	gs.recordSighting(Player{
+		Username: "defender",
+		Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "asia", HP: 5, XP: 1},
+			2: {ID: 2, Rank: RankInfantry, Location: "australia"},
+		},
+	}, "asia")
+	rw := RecognitionOfWar{
+		ID:       "war",
+		Attacker: gs.GetPlayerSnap(),
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{3: {ID: 3, Rank: RankInfantry, Location: "europe"}},
+		},
+		Location: "europe",
+		DecideBy: time.Now().Add(WarDecisionWindow),
+		Model:    CombatModelPower,
+		Rules:    DefaultCombatConfig(),
+	}
+	gs.addOpenWar(rw)
+
+	res, ok := gs.HandleWarDecision(WarDecision{
+		WarID:    "war",
+		Attacker: "attacker",
+		Username: "defender",
+		Choice:   WarChoiceReinforce,
+		Units: []Unit{
+			// healed and trained on the way
+			{ID: 1, Rank: RankInfantry, Location: "asia", HP: 10, XP: 10},
+			// never seen
+			{ID: 4, Rank: RankArtillery, Location: "asia"},
+			// not next to europe
+			{ID: 2, Rank: RankInfantry, Location: "australia"},
+		},
+	})
+	if !ok {
+		t.Fatal("expected the war to be fought once the defender decided")
+	}
+	want := []Unit{{ID: 1, Rank: RankInfantry, Location: "asia", HP: 5, XP: 1}}
+	if !reflect.DeepEqual(res.Decisions[0].Units, want) {
+		t.Errorf("expected only the reinforcement seen in asia as it was seen, got %v", res.Decisions[0].Units)
+	}
+}
+
+func TestApplyWarDecisionsIgnoresFarAwayReinforcements(t *testing.T) {
+	rw := RecognitionOfWar{
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+		},
+		Location: "europe",
+	}
+	decisions := []WarDecision{{
+		Username: "defender",
+		Choice:   WarChoiceReinforce,
+		Units: []Unit{
+			{ID: 1, Rank: RankArtillery, Location: "asia"},
+			{ID: 2, Rank: RankArtillery, Location: "australia"},
+			{ID: 3, Rank: RankArtillery, Location: "africa"},
+		},
+	}}
+
+	got := applyWarDecisions(rw, decisions).Defender.Units
+	want := map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		3: {ID: 3, Rank: RankArtillery, Location: "europe"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected only africa's artillery to join the infantry already fighting, got %v", got)
+	}
+}
+
+func TestResolveWarAfterTheDefenderRetreats(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankArtillery, Location: "europe"}},
+		},
+		Location: "europe",
+		Model:    CombatModelPower,
+		Rules:    DefaultCombatConfig(),
+	}
+	decisions := []WarDecision{{WarID: "war", Username: "defender", Choice: WarChoiceRetreat, To: "asia"}}
+
+	res, ok := resolveWarWithDecisions(rw, decisions)
+	if !ok {
+		t.Fatal("expected the war to be fought")
+	}
+	if len(res.Battles) != 0 || len(res.Casualties) != 0 {
+		t.Errorf("expected no battle after a retreat, got %v battles and casualties %v", len(res.Battles), res.Casualties)
+	}
+	if res.Winner != "attacker" {
+		t.Errorf("expected attacker to hold the battlefield, got %q", res.Winner)
+	}
+	if !reflect.DeepEqual(res.Decisions, decisions) {
+		t.Errorf("expected the resolution to carry the decisions, got %v", res.Decisions)
+	}
+	if len(rw.Defender.Units) != 1 {
+		t.Error("expected the recognition to be left untouched")
+	}
+}
+
+func TestResolveWarWithReinforcements(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+				2: {ID: 2, Rank: RankArtillery, Location: "asia"},
+			},
+		},
+		Location: "europe",
+		Model:    CombatModelPower,
+		Rules:    neutralCombatConfig(),
+	}
+
+	res, _ := resolveWar(rw)
+	if res.Winner != "attacker" {
+		t.Fatalf("expected attacker to win without reinforcements, got %q", res.Winner)
+	}
+
+	decisions := []WarDecision{{
+		WarID:    "war",
+		Username: "defender",
+		Choice:   WarChoiceReinforce,
+		Units:    []Unit{{ID: 2, Rank: RankArtillery, Location: "asia"}},
+	}}
+	res, _ = resolveWarWithDecisions(rw, decisions)
+	if res.Winner != "defender" {
+		t.Errorf("expected the reinforced defender to win, got %q", res.Winner)
+	}
+	want := map[string][]int{"attacker": {1}}
+	if !reflect.DeepEqual(res.Casualties, want) {
+		t.Errorf("expected casualties %v, got %v", want, res.Casualties)
+	}
+}
@@ -0,0 +1,338 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type TreatyKind string
+
+const (
+	// allies never fight each other and defend each other's locations
+	TreatyAlliance = "alliance"
+	// pact members never fight each other
+	TreatyPact = "pact"
+	// a ceasefire is a pact that runs out
+	TreatyCeasefire = "ceasefire"
+)
+
+type DiplomacyAction string
+
+const (
+	DiplomacyPropose = "propose"
+	DiplomacyAccept  = "accept"
+	DiplomacyReject  = "reject"
+	DiplomacyBreak   = "break"
+)
+
+const defaultCeasefire = 5 * time.Minute
+
+type Treaty struct {
+	Kind   TreatyKind
+	With   string
+	Since  time.Time
+	Expiry time.Time
+}
+
+// DiplomacyMessage is exchanged between two players. Duration is only set
+// on ceasefire proposals, Expiry on the acceptance of one.
+type DiplomacyMessage struct {
+	From     string
+	To       string
+	Room     string
+	Action   DiplomacyAction
+	Kind     TreatyKind
+	Duration time.Duration
+	Expiry   time.Time
+	SentAt   time.Time
+}
+
+func getAllTreatyKinds() map[TreatyKind]struct{} {
+	return map[TreatyKind]struct{}{
+		TreatyAlliance:  {},
+		TreatyPact:      {},
+		TreatyCeasefire: {},
+	}
+}
+
+// CommandDiplomacy lists treaties and proposals, or proposes, accepts,
+// rejects or breaks one. ok is false when there is nothing to send.
+func (gs *GameState) CommandDiplomacy(words []string) (msg DiplomacyMessage, ok bool, err error) {
+	if len(words) < 2 {
+		gs.printDiplomacy()
+		return DiplomacyMessage{}, false, nil
+	}
+	usage := errors.New("usage: diplomacy [propose <player> <alliance|pact|ceasefire> [duration] | accept <player> | reject <player> | break <player>]")
+	if len(words) < 3 {
+		return DiplomacyMessage{}, false, usage
+	}
+	other := words[2]
+	if other == gs.GetUsername() {
+		return DiplomacyMessage{}, false, errors.New("error: you can not make a treaty with yourself")
+	}
+	msg = DiplomacyMessage{
+		From:   gs.GetUsername(),
+		To:     other,
+		Room:   gs.GetRoom(),
+		Action: DiplomacyAction(words[1]),
+		SentAt: time.Now(),
+	}
+
+	switch words[1] {
+	case DiplomacyPropose:
+		if len(words) < 4 {
+			return DiplomacyMessage{}, false, usage
+		}
+		msg.Kind = TreatyKind(words[3])
+		if _, ok := getAllTreatyKinds()[msg.Kind]; !ok {
+			return DiplomacyMessage{}, false, fmt.Errorf("error: %s is not a valid treaty", words[3])
+		}
+		if msg.Kind == TreatyCeasefire {
+			msg.Duration = defaultCeasefire
+			if len(words) > 4 {
+				d, err := time.ParseDuration(words[4])
+				if err != nil || d <= 0 {
+					return DiplomacyMessage{}, false, fmt.Errorf("error: %s is not a valid duration", words[4])
+				}
+				msg.Duration = d
+			}
+		}
+		gs.addProposal(msg)
+		fmt.Printf("Proposed a %s to %s\n", msg.Kind, other)
+	case DiplomacyAccept:
+		proposal, ok := gs.popProposal(false, other)
+		if !ok {
+			return DiplomacyMessage{}, false, fmt.Errorf("error: %s has not proposed anything", other)
+		}
+		msg.Kind = proposal.Kind
+		if proposal.Duration > 0 {
+			msg.Expiry = msg.SentAt.Add(proposal.Duration)
+		}
+		gs.setTreaty(Treaty{Kind: msg.Kind, With: other, Since: msg.SentAt, Expiry: msg.Expiry})
+		fmt.Printf("You accepted the %s with %s\n", msg.Kind, other)
+	case DiplomacyReject:
+		proposal, ok := gs.popProposal(false, other)
+		if !ok {
+			return DiplomacyMessage{}, false, fmt.Errorf("error: %s has not proposed anything", other)
+		}
+		msg.Kind = proposal.Kind
+		fmt.Printf("You rejected the %s with %s\n", msg.Kind, other)
+	case DiplomacyBreak:
+		treaty, ok := gs.getTreaty(other)
+		if !ok {
+			return DiplomacyMessage{}, false, fmt.Errorf("error: you have no treaty with %s", other)
+		}
+		gs.breakTreaty(other)
+		msg.Kind = treaty.Kind
+		fmt.Printf("You broke the %s with %s\n", msg.Kind, other)
+	default:
+		return DiplomacyMessage{}, false, usage
+	}
+	return msg, true, nil
+}
+
+// HandleDiplomacy applies a diplomacy message sent to the player.
+func (gs *GameState) HandleDiplomacy(msg DiplomacyMessage) {
+	if msg.To != gs.GetUsername() {
+		return
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Diplomacy ====")
+	switch msg.Action {
+	case DiplomacyPropose:
+		gs.addProposal(msg)
+		fmt.Printf("%s proposes a %s", msg.From, msg.Kind)
+		if msg.Duration > 0 {
+			fmt.Printf(" for %v", msg.Duration)
+		}
+		fmt.Println(".")
+		fmt.Printf("Type 'diplomacy accept %s' or 'diplomacy reject %s'.\n", msg.From, msg.From)
+	case DiplomacyAccept:
+		proposal, ok := gs.popProposal(true, msg.From)
+		if !ok || proposal.Kind != msg.Kind {
+			fmt.Printf("%s accepted a %s you never proposed.\n", msg.From, msg.Kind)
+			return
+		}
+		gs.setTreaty(Treaty{Kind: msg.Kind, With: msg.From, Since: msg.SentAt, Expiry: msg.Expiry})
+		fmt.Printf("%s accepted your %s!\n", msg.From, msg.Kind)
+	case DiplomacyReject:
+		gs.popProposal(true, msg.From)
+		fmt.Printf("%s rejected your %s.\n", msg.From, msg.Kind)
+	case DiplomacyBreak:
+		gs.breakTreaty(msg.From)
+		fmt.Printf("%s broke the %s with you!\n", msg.From, msg.Kind)
+	}
+}
+
+// RoutingKey is where a diplomacy message is published, e.g. r1.diplomacy.bob.
+func (msg DiplomacyMessage) RoutingKey() string {
+	return routing.RoomKey(msg.Room, routing.DiplomacyPrefix, msg.To)
+}
+
+func (gs *GameState) printDiplomacy() {
+	treaties := gs.getTreatiesSnap()
+	if len(treaties) == 0 {
+		fmt.Println("You have no treaties.")
+	}
+	for _, treaty := range treaties {
+		fmt.Printf("* %s with %s", treaty.Kind, treaty.With)
+		if !treaty.Expiry.IsZero() {
+			fmt.Printf(", ends in %v", time.Until(treaty.Expiry).Round(time.Second))
+		}
+		fmt.Println()
+	}
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	for _, from := range sortedProposals(gs.proposalsReceived) {
+		fmt.Printf("* %s proposes a %s\n", from, gs.proposalsReceived[from].Kind)
+	}
+	for _, to := range sortedProposals(gs.proposalsSent) {
+		fmt.Printf("* you proposed a %s to %s\n", gs.proposalsSent[to].Kind, to)
+	}
+}
+
+// sortedProposals is the players in proposals, sorted.
+func sortedProposals(proposals map[string]DiplomacyMessage) []string {
+	usernames := []string{}
+	for username := range proposals {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// HandleDiplomacy records the treaties the server overhears players agree
+// to, so it can tell everyone in a battlefield who is at peace with whom.
+// A treaty only counts once the player it was proposed to accepted it.
+func (w *World) HandleDiplomacy(msg DiplomacyMessage) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if msg.Room != w.room || msg.From == msg.To {
+		return
+	}
+	switch msg.Action {
+	case DiplomacyPropose:
+		if _, ok := w.proposals[msg.From]; !ok {
+			w.proposals[msg.From] = map[string]DiplomacyMessage{}
+		}
+		w.proposals[msg.From][msg.To] = msg
+	case DiplomacyAccept:
+		proposal, ok := w.proposals[msg.To][msg.From]
+		if !ok || proposal.Kind != msg.Kind {
+			return
+		}
+		delete(w.proposals[msg.To], msg.From)
+		expiry := time.Time{}
+		if proposal.Duration > 0 {
+			expiry = msg.Expiry
+		}
+		w.setTreaty(msg.From, Treaty{Kind: msg.Kind, With: msg.To, Since: msg.SentAt, Expiry: expiry})
+		w.setTreaty(msg.To, Treaty{Kind: msg.Kind, With: msg.From, Since: msg.SentAt, Expiry: expiry})
+	case DiplomacyReject:
+		delete(w.proposals[msg.To], msg.From)
+	case DiplomacyBreak:
+		delete(w.treaties[msg.From], msg.To)
+		delete(w.treaties[msg.To], msg.From)
+	}
+}
+
+// setTreaty must be called with the lock held.
+func (w *World) setTreaty(username string, treaty Treaty) {
+	if _, ok := w.treaties[username]; !ok {
+		w.treaties[username] = map[string]Treaty{}
+	}
+	w.treaties[username][treaty.With] = treaty
+}
+
+// treatiesAmong must be called with the lock held. It is the treaties still
+// in force between the players, keyed by player and sorted by the other one.
+func (w *World) treatiesAmong(usernames []string) map[string][]Treaty {
+	treaties := map[string][]Treaty{}
+	for _, username := range usernames {
+		for _, other := range usernames {
+			treaty, ok := w.treaties[username][other]
+			if !ok || (!treaty.Expiry.IsZero() && time.Now().After(treaty.Expiry)) {
+				continue
+			}
+			treaties[username] = append(treaties[username], treaty)
+		}
+	}
+	return treaties
+}
+
+// atPeaceWith reports whether the player has any treaty with username that
+// keeps them from fighting.
+func (gs *GameState) atPeaceWith(username string) bool {
+	_, ok := gs.getTreaty(username)
+	return ok
+}
+
+func (gs *GameState) isAlly(username string) bool {
+	treaty, ok := gs.getTreaty(username)
+	return ok && treaty.Kind == TreatyAlliance
+}
+
+func (gs *GameState) getTreaty(username string) (Treaty, bool) {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	treaty, ok := gs.treaties[username]
+	if !ok || (!treaty.Expiry.IsZero() && time.Now().After(treaty.Expiry)) {
+		return Treaty{}, false
+	}
+	return treaty, true
+}
+
+// getTreatiesSnap returns the treaties still in force sorted by player.
+func (gs *GameState) getTreatiesSnap() []Treaty {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	treaties := []Treaty{}
+	for _, treaty := range gs.treaties {
+		if treaty.Expiry.IsZero() || time.Now().Before(treaty.Expiry) {
+			treaties = append(treaties, treaty)
+		}
+	}
+	sort.Slice(treaties, func(i, j int) bool { return treaties[i].With < treaties[j].With })
+	return treaties
+}
+
+func (gs *GameState) setTreaty(treaty Treaty) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.treaties[treaty.With] = treaty
+}
+
+func (gs *GameState) breakTreaty(username string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.treaties, username)
+}
+
+// addProposal stores a proposal the player sent or received, keyed by the
+// other player.
+func (gs *GameState) addProposal(msg DiplomacyMessage) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if msg.From == gs.Player.Username {
+		gs.proposalsSent[msg.To] = msg
+		return
+	}
+	gs.proposalsReceived[msg.From] = msg
+}
+
+func (gs *GameState) popProposal(sent bool, username string) (DiplomacyMessage, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	proposals := gs.proposalsReceived
+	if sent {
+		proposals = gs.proposalsSent
+	}
+	msg, ok := proposals[username]
+	delete(proposals, username)
+	return msg, ok
+}
@@ -0,0 +1,313 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecognitionOfWarTakesAlliesFromTheBattlefield(t *testing.T) {
+	gs := NewGameState("bob")
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	treaties := map[string][]Treaty{"bob": {{Kind: TreatyAlliance, With: "carol", Since: time.Now()}}}
+	// intel still has carol in europe, but carol already left
+	gs.recordSighting(Player{
+		Username: "carol",
+		Units:    map[int]Unit{7: {ID: 7, Rank: RankArtillery, Location: "europe"}},
+	}, "europe")
+	attacker := Player{
+		Username: "alice",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+	}
+	dave := Player{
+		Username: "dave",
+		Units:    map[int]Unit{2: {ID: 2, Rank: RankInfantry, Location: "europe"}},
+	}
+
+	rw, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker, dave}})
+	if !ok {
+		t.Fatal("expected bob to lead the defense")
+	}
+	if len(rw.Allies) != 0 {
+		t.Errorf("expected no allies once carol left, got %v", rw.Allies)
+	}
+
+	// carol really is there, with other units than intel remembers
+	carol := Player{
+		Username: "carol",
+		Units:    map[int]Unit{9: {ID: 9, Rank: RankInfantry, Location: "europe"}},
+	}
+	rw, _ = gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker, carol, dave}, Treaties: treaties})
+	if !reflect.DeepEqual(rw.Allies, []Player{carol}) {
+		t.Errorf("expected carol to fight with the units really there, got %v", rw.Allies)
+	}
+}
+
+func TestRecognitionOfWarTakesOthersFromTheBattlefield(t *testing.T) {
+	gs := NewGameState("bob")
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	attacker := Player{
+		Username: "alice",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+	}
+	// bob never saw dave move in, the server did
+	dave := Player{
+		Username: "dave",
+		Units:    map[int]Unit{2: {ID: 2, Rank: RankInfantry, Location: "europe"}},
+	}
+	erin := Player{
+		Username: "erin",
+		Units:    map[int]Unit{3: {ID: 3, Rank: RankInfantry, Location: "europe"}},
+	}
+
+	bf := Battlefield{
+		Location: "europe",
+		Players:  []Player{attacker, dave, erin},
+		Treaties: map[string][]Treaty{"bob": {{Kind: TreatyPact, With: "erin", Since: time.Now()}}},
+	}
+	rw, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, bf)
+	if !ok {
+		t.Fatal("expected bob to lead the defense")
+	}
+	if !reflect.DeepEqual(rw.Others, []Player{dave}) {
+		t.Errorf("expected dave to be caught in the war and erin to stay out of it, got %v", rw.Others)
+	}
+	want := []string{"alice", "bob", "dave"}
+	if !reflect.DeepEqual(rw.Participants(), want) {
+		t.Errorf("expected participants %v, got %v", want, rw.Participants())
+	}
+}
+
+func TestWarLeaderIsConfirmedByTheServer(t *testing.T) {
+	gs := NewGameState("carol")
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	// intel has bob in europe, who would lead the defense from there
+	bob := Player{
+		Username: "bob",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+	gs.recordSighting(bob, "europe")
+	attacker := Player{
+		Username: "alice",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+	}
+
+	if _, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker}}); !ok {
+		t.Error("expected carol to declare the war once bob left")
+	}
+	if _, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker, bob}}); ok {
+		t.Error("expected carol to leave the declaration to bob")
+	}
+	// carol's own treaties have no say in who leads
+	gs.setTreaty(Treaty{Kind: TreatyPact, With: "bob", Since: time.Now()})
+	if _, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker, bob}}); ok {
+		t.Error("expected carol to still leave the declaration to bob")
+	}
+}
+
+func TestWarLeaderAtPeaceWithTheAttackerStaysOut(t *testing.T) {
+	gs := NewGameState("carol")
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	bob := Player{
+		Username: "bob",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+	attacker := Player{
+		Username: "alice",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+	}
+	bf := Battlefield{
+		Location: "europe",
+		Players:  []Player{attacker, bob},
+		Treaties: map[string][]Treaty{"alice": {{Kind: TreatyPact, With: "bob"}}},
+	}
+
+	rw, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, bf)
+	if !ok {
+		t.Fatal("expected carol to declare the war while bob keeps the peace")
+	}
+	if len(rw.Others) != 0 {
+		t.Errorf("expected bob to stay out of the war, got %v", rw.Others)
+	}
+}
+
+func TestMixedTreatiesAgreeOnTheSides(t *testing.T) {
+	attacker := Player{
+		Username: "alice",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+	}
+	players := []Player{attacker}
+	defenders := []*GameState{}
+	for _, username := range []string{"bob", "carol", "dave", "erin"} {
+		gs := NewGameState(username)
+		gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+		defenders = append(defenders, gs)
+		players = append(players, Player{Username: username, Units: gs.GetPlayerSnap().Units})
+	}
+	// bob keeps a pact with alice, carol and erin are allied, dave is on its own
+	bf := Battlefield{
+		Location: "europe",
+		Players:  players,
+		Treaties: map[string][]Treaty{
+			"alice": {{Kind: TreatyPact, With: "bob"}},
+			"carol": {{Kind: TreatyAlliance, With: "erin"}},
+		},
+	}
+
+	wars := []RecognitionOfWar{}
+	for _, gs := range defenders {
+		if rw, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, bf); ok {
+			wars = append(wars, rw)
+		}
+	}
+	if len(wars) != 1 {
+		t.Fatalf("expected exactly one war, got %v", len(wars))
+	}
+	rw := wars[0]
+	if rw.Defender.Username != "carol" {
+		t.Errorf("expected carol to lead, got %v", rw.Defender.Username)
+	}
+	if len(rw.Allies) != 1 || rw.Allies[0].Username != "erin" {
+		t.Errorf("expected erin at carol's side, got %v", rw.Allies)
+	}
+	if len(rw.Others) != 1 || rw.Others[0].Username != "dave" {
+		t.Errorf("expected dave to fight for itself, got %v", rw.Others)
+	}
+	want := []string{"alice", "carol", "erin", "dave"}
+	if !reflect.DeepEqual(rw.Participants(), want) {
+		t.Errorf("expected participants %v without bob, got %v", want, rw.Participants())
+	}
+}
+
+func TestAlliedDefendersDeclareOneWar(t *testing.T) {
+	attacker := Player{
+		Username: "alice",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankCavalry, Location: "europe"}},
+	}
+	players := []Player{attacker}
+	defenders := []*GameState{}
+	for _, username := range []string{"bob", "carol"} {
+		gs := NewGameState(username)
+		gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+		defenders = append(defenders, gs)
+		players = append(players, Player{Username: username, Units: gs.GetPlayerSnap().Units})
+	}
+	defenders[0].setTreaty(Treaty{Kind: TreatyAlliance, With: "carol"})
+	defenders[1].setTreaty(Treaty{Kind: TreatyAlliance, With: "bob"})
+	bf := Battlefield{
+		Location: "europe",
+		Players:  players,
+		Treaties: map[string][]Treaty{
+			"bob":   {{Kind: TreatyAlliance, With: "carol"}},
+			"carol": {{Kind: TreatyAlliance, With: "bob"}},
+		},
+	}
+
+	declared := []string{}
+	for _, gs := range defenders {
+		if rw, ok := gs.NewRecognitionOfWar(ArmyMove{Player: attacker}, bf); ok {
+			declared = append(declared, rw.Defender.Username)
+			if len(rw.Allies) != 1 {
+				t.Errorf("expected the leader to bring its ally, got %v", rw.Allies)
+			}
+		}
+	}
+	if !reflect.DeepEqual(declared, []string{"bob"}) {
+		t.Errorf("expected only bob to declare the war, got %v", declared)
+	}
+}
+
+func TestWorldBattlefield(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankCavalry, Location: "australia"},
+	}})
+
+	bf, err := w.Battlefield("alice", "europe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Player{{Username: "alice", Units: map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}}}}
+	if !reflect.DeepEqual(bf.Players, want) {
+		t.Errorf("expected only alice's unit in europe, got %v", bf.Players)
+	}
+	if _, err := w.Battlefield("bob", "europe"); err == nil {
+		t.Error("expected bob not to see europe from australia")
+	}
+}
+
+func TestWorldBattlefieldCarriesAgreedTreaties(t *testing.T) {
+	w := NewWorld("r1")
+	for _, username := range []string{"alice", "bob", "carol"} {
+		w.HandlePlayerState(Player{Username: username, Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		}})
+	}
+	// an acceptance nobody proposed makes no treaty
+	w.HandleDiplomacy(DiplomacyMessage{From: "carol", To: "alice", Room: "r1", Action: DiplomacyAccept, Kind: TreatyPact})
+	w.HandleDiplomacy(DiplomacyMessage{From: "alice", To: "bob", Room: "r1", Action: DiplomacyPropose, Kind: TreatyPact})
+	w.HandleDiplomacy(DiplomacyMessage{From: "bob", To: "alice", Room: "r1", Action: DiplomacyAccept, Kind: TreatyPact})
+
+	bf, err := w.Battlefield("carol", "europe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bf.atPeace("alice", "bob") || !bf.atPeace("bob", "alice") {
+		t.Errorf("expected alice and bob to be at peace, got %v", bf.Treaties)
+	}
+	if bf.atPeace("alice", "carol") {
+		t.Errorf("expected carol to have no treaty, got %v", bf.Treaties)
+	}
+
+	w.HandleDiplomacy(DiplomacyMessage{From: "bob", To: "alice", Room: "r1", Action: DiplomacyBreak, Kind: TreatyPact})
+	bf, _ = w.Battlefield("carol", "europe")
+	if bf.atPeace("alice", "bob") {
+		t.Errorf("expected the broken pact to be gone, got %v", bf.Treaties)
+	}
+}
+
+func TestResolveWarAlliesShareTheDefendersFate(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+				2: {ID: 2, Rank: RankArtillery, Location: "europe"},
+			},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+		},
+		// unit IDs are only unique per player
+		Allies: []Player{{
+			Username: "ally",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+				2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+			},
+		}},
+		Model: CombatModelPower,
+		Rules: DefaultCombatConfig(),
+	}
+
+	res, ok := resolveWar(rw)
+	if !ok {
+		t.Fatal("expected the war to be fought")
+	}
+	if res.Winner != "attacker" {
+		t.Errorf("expected attacker to win, got %q", res.Winner)
+	}
+	want := map[string][]int{"defender": {1}, "ally": {1}}
+	if !reflect.DeepEqual(res.Casualties, want) {
+		t.Errorf("expected casualties %v, got %v", want, res.Casualties)
+	}
+	if !reflect.DeepEqual(res.Participants(), []string{"attacker", "defender", "ally"}) {
+		t.Errorf("expected the ally to take part, got %v", res.Participants())
+	}
+}
@@ -0,0 +1,83 @@
+package gamelogic
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+)
+
+const StartingTreasury = 100
+
+func (gs *GameState) HandleTreasuryUpdate(tu TreasuryUpdate) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Treasury Update ====")
+	gs.setTreasury(tu.Balance)
+	if len(tu.Refused) > 0 {
+		gs.removeUnits(tu.Refused)
+		fmt.Printf("The server refused units %v, you could not afford them.\n", tu.Refused)
+	}
+	if tu.Income > 0 {
+		fmt.Printf("Your territories yielded %v gold.\n", tu.Income)
+	}
+	if tu.Reason != "" {
+		fmt.Println(tu.Reason)
+	}
+	fmt.Printf("Your treasury now holds %v gold.\n", tu.Balance)
+}
+
+// holdings are the locations a player has units in.
+func holdings(p Player) []Location {
+	held := map[Location]struct{}{}
+	for _, unit := range p.Units {
+		held[unit.Location] = struct{}{}
+	}
+	locations := []Location{}
+	for loc := range held {
+		locations = append(locations, loc)
+	}
+	return locations
+}
+
+func incomeFor(p Player) int {
+	incomes := getAllLocationIncomes()
+	income := 0
+	for _, loc := range holdings(p) {
+		income += incomes[loc]
+	}
+	return income
+}
+
+// chargeSpawns works out what the units in next that weren't in prev cost,
+// a unit that changed rank counts as a new one. They are paid for in the
+// order of their ids as long as balance allows it. The rest are refused and
+// next keeps prev's version of them, if there was one.
+func chargeSpawns(prev, next Player, balance int) (Player, int, []int) {
+	costs := getAllRankCosts()
+	ids := []int{}
+	for id, unit := range next.Units {
+		if old, ok := prev.Units[id]; !ok || old.Rank != unit.Rank {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	units := maps.Clone(next.Units)
+	cost := 0
+	refused := []int{}
+	for _, id := range ids {
+		unitCost, ok := costs[units[id].Rank]
+		if !ok || cost+unitCost > balance {
+			refused = append(refused, id)
+			if old, ok := prev.Units[id]; ok {
+				units[id] = old
+			} else {
+				delete(units, id)
+			}
+			continue
+		}
+		cost += unitCost
+	}
+	next.Units = units
+	return next, cost, refused
+}
@@ -0,0 +1,122 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandlePlayerStateRefusesUnaffordableSpawns(t *testing.T) {
+	w := NewWorld("r1")
+	// 50 + 50 + 10 gold out of a starting treasury of 100
+	tu, changed := w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+		2: {ID: 2, Rank: RankArtillery, Location: "europe"},
+		3: {ID: 3, Rank: RankInfantry, Location: "europe"},
+	}})
+	if !changed {
+		t.Fatal("expected a treasury update")
+	}
+	if tu.Balance != 0 {
+		t.Errorf("expected the treasury to be spent, got %v", tu.Balance)
+	}
+	if !reflect.DeepEqual(tu.Refused, []int{3}) {
+		t.Errorf("expected unit 3 to be refused, got %v", tu.Refused)
+	}
+	if _, ok := w.players["alice"].Units[3]; ok {
+		t.Error("expected the refused unit not to be recorded")
+	}
+}
+
+func TestHandlePlayerStateChargesRankChanges(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	tu, _ := w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankCavalry, Location: "europe"},
+	}})
+	if tu.Balance != StartingTreasury-10-30 {
+		t.Errorf("expected the new rank to be paid for, got a balance of %v", tu.Balance)
+	}
+
+	// with a second cavalry only 30 gold are left, not enough for artillery
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankCavalry, Location: "europe"},
+		2: {ID: 2, Rank: RankCavalry, Location: "europe"},
+	}})
+	tu, _ = w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+		2: {ID: 2, Rank: RankCavalry, Location: "europe"},
+	}})
+	if tu.Balance != 30 || !reflect.DeepEqual(tu.Refused, []int{1}) {
+		t.Errorf("expected the rank change to be refused, got a balance of %v and refused %v", tu.Balance, tu.Refused)
+	}
+	if got := w.players["alice"].Units[1].Rank; got != RankCavalry {
+		t.Errorf("expected the refused unit to keep its rank, got %v", got)
+	}
+}
+
+func TestChargeSpawnsRefusesUnknownRanks(t *testing.T) {
+	next, cost, refused := chargeSpawns(Player{}, Player{Units: map[int]Unit{
+		1: {ID: 1, Rank: "dragon", Location: "europe"},
+	}}, StartingTreasury)
+	if cost != 0 || !reflect.DeepEqual(refused, []int{1}) || len(next.Units) != 0 {
+		t.Errorf("expected the unknown rank to be refused, got cost %v, refused %v, units %v", cost, refused, next.Units)
+	}
+}
+
+func TestRejoiningKeepsTheTreasury(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+	}})
+	w.RemovePlayer("alice")
+
+	tu, changed := w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{}})
+	if !changed || tu.Balance != StartingTreasury-50 {
+		t.Errorf("expected alice to get the old balance back, got %v", tu.Balance)
+	}
+}
+
+func TestHandlePlayerStateKeepsTheServersUnits(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		// a new unit can not bring wounds or experience with it either
+		1: {ID: 1, Rank: RankInfantry, Location: "europe", XP: 50},
+	}})
+	w.players["alice"].Units[1] = Unit{ID: 1, Rank: RankInfantry, Location: "europe", HP: 3}
+
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "antarctica", HP: 10, XP: 50},
+	}})
+	want := Unit{ID: 1, Rank: RankInfantry, Location: "europe", HP: 3}
+	if got := w.players["alice"].Units[1]; got != want {
+		t.Errorf("expected the server's %+v, got %+v", want, got)
+	}
+
+	w.HandleMove(ArmyMove{Player: Player{Username: "alice"}, ToLocation: "asia", Units: []Unit{{ID: 1}}})
+	if got := w.players["alice"].Units[1].Location; got != "asia" {
+		t.Errorf("expected the relayed move to count, got %v", got)
+	}
+}
+
+func TestUnitsTheServerRemovedStayDead(t *testing.T) {
+	w := NewWorld("r1")
+	units := map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "antarctica"},
+		2: {ID: 2, Rank: RankInfantry, Location: "antarctica"},
+		3: {ID: 3, Rank: RankInfantry, Location: "antarctica"},
+	}
+	w.HandlePlayerState(Player{Username: "alice", Units: units})
+	w.players["alice"].Units[3] = Unit{ID: 3, Rank: RankInfantry, Location: "antarctica", HP: 1}
+	w.Resupply()
+	balance := w.treasuries["alice"]
+
+	tu, _ := w.HandlePlayerState(Player{Username: "alice", Units: units})
+	if _, ok := w.players["alice"].Units[3]; ok {
+		t.Error("expected the unit lost to attrition not to come back")
+	}
+	if tu.Balance != balance {
+		t.Errorf("expected nothing to be charged, got a balance of %v instead of %v", tu.Balance, balance)
+	}
+}
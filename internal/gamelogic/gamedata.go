@@ -1,5 +1,7 @@
 package gamelogic
 
+import "time"
+
 type Player struct {
 	Username string
 	Units    map[int]Unit
@@ -13,21 +15,122 @@ const (
 	RankArtillery = "artillery"
 )
 
+type UnitVeterancy string
+
+const (
+	VeterancyRecruit = "recruit"
+	VeterancyVeteran = "veteran"
+	VeterancyElite   = "elite"
+)
+
+// Unit is one of a player's units. HP is what it has left of its rank's
+// maximum, units that never took damage may leave it at 0. XP is gained by
+// surviving battles and promotes the unit.
 type Unit struct {
 	ID       int
 	Rank     UnitRank
 	Location Location
+	HP       int
+	XP       int
 }
 
+// ArmyMove carries the combat model and rules the mover wants its wars
+// fought with, they only apply if the defender chose the same.
 type ArmyMove struct {
 	Player     Player
 	Units      []Unit
 	ToLocation Location
+	Model      CombatModel
+	Rules      CombatConfig
 }
 
+// TreasuryUpdate is published by the server, which owns every player's
+// balance. Clients only ever mirror it. Refused lists the ids of units the
+// player could not afford, the server did not record them.
+type TreasuryUpdate struct {
+	Username string
+	Balance  int
+	Income   int
+	Reason   string
+	Refused  []int
+}
+
+// SupplyUpdate is published by the server at the end of every turn. It
+// lists the player's locations cut off from its home base and the HP each
+// unit loses to attrition for being stacked beyond a location's supply.
+type SupplyUpdate struct {
+	Username    string
+	Home        Location
+	OutOfSupply []Location
+	Attrition   map[int]int
+}
+
+type CombatModel string
+
+const (
+	CombatModelPower = "power"
+	CombatModelDice  = "dice"
+)
+
+// RecognitionOfWar carries the combat model, its rules and the seed for its
+// dice so that every participant computes the same result.
 type RecognitionOfWar struct {
+	ID       string
 	Attacker Player
 	Defender Player
+	// the defender's allies with units in the battlefield fight at its side
+	Allies []Player
+	// everyone else in the battlefield fights for itself
+	Others []Player
+	// where the war is fought, and until when the players attacked there
+	// can retreat, surrender or call reinforcements
+	Location Location
+	DecideBy time.Time
+	Model    CombatModel
+	Rules    CombatConfig
+	Seed     int64
+	// players whose units in the battlefield the server found cut off from
+	// their home base
+	CutOff []string
+}
+
+// Battle is one fight between two sides of a war, named after their
+// leaders.
+type Battle struct {
+	Attacker      string
+	Defender      string
+	AttackerPower float64
+	DefenderPower float64
+	Winner        string
+	Report        BattleReport
+}
+
+// WarResolution is computed once by the attacker and published to every
+// participant, who apply their own casualties from it. Winner is empty when
+// no side holds the battlefield alone, Losers are the leaders of the sides
+// that lost. Decisions are the ones the war was fought with.
+type WarResolution struct {
+	WarID      string
+	Attacker   string
+	Defender   string
+	Allies     []string
+	Others     []string
+	Location   Location
+	Battles    []Battle
+	Winner     string
+	Losers     []string
+	Casualties map[string][]int
+	// every unit that fought and lived, with its wounds and experience
+	Survivors map[string][]Unit
+	Decisions []WarDecision
+}
+
+// WarAck is sent to every other participant once a WarResolution has been
+// applied.
+type WarAck struct {
+	WarID    string
+	Username string
+	Agreed   bool
 }
 
 type Location string
@@ -40,6 +143,64 @@ func getAllRanks() map[UnitRank]struct{} {
 	}
 }
 
+func getAllRankCosts() map[UnitRank]int {
+	return map[UnitRank]int{
+		RankInfantry:  10,
+		RankCavalry:   30,
+		RankArtillery: 50,
+	}
+}
+
+// getAllRankHP is the health a unit of each rank is spawned with.
+func getAllRankHP() map[UnitRank]int {
+	return map[UnitRank]int{
+		RankInfantry:  10,
+		RankCavalry:   15,
+		RankArtillery: 20,
+	}
+}
+
+// getVeterancyThresholds is the XP a unit needs for each promotion.
+func getVeterancyThresholds() map[UnitVeterancy]int {
+	return map[UnitVeterancy]int{
+		VeterancyRecruit: 0,
+		VeterancyVeteran: 3,
+		VeterancyElite:   6,
+	}
+}
+
+// getAllLocationIncomes is what holding each location yields per income tick.
+func getAllLocationIncomes() map[Location]int {
+	return map[Location]int{
+		"americas":   15,
+		"europe":     15,
+		"africa":     10,
+		"asia":       15,
+		"australia":  10,
+		"antarctica": 5,
+	}
+}
+
+// getAllLocationSupply is how many units each location can keep supplied,
+// any more suffer attrition.
+func getAllLocationSupply() map[Location]int {
+	return map[Location]int{
+		"americas":   8,
+		"europe":     6,
+		"africa":     5,
+		"asia":       8,
+		"australia":  4,
+		"antarctica": 2,
+	}
+}
+
+func getAllCombatModels() map[CombatModel]struct{} {
+	return map[CombatModel]struct{}{
+		CombatModelPower: {},
+		CombatModelDice:  {},
+	}
+}
+
 func getAllLocations() map[Location]struct{} {
 	return map[Location]struct{}{
 		"americas":   {},
@@ -50,3 +211,16 @@ func getAllLocations() map[Location]struct{} {
 		"antarctica": {},
 	}
 }
+
+// getAdjacentLocations lists the locations a player can see into from each
+// location it occupies.
+func getAdjacentLocations() map[Location][]Location {
+	return map[Location][]Location{
+		"americas":   {"europe", "africa", "asia", "antarctica"},
+		"europe":     {"americas", "africa", "asia"},
+		"africa":     {"americas", "europe", "asia", "antarctica"},
+		"asia":       {"americas", "europe", "africa", "australia"},
+		"australia":  {"asia", "antarctica"},
+		"antarctica": {"americas", "africa", "australia"},
+	}
+}
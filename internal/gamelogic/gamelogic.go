@@ -6,21 +6,52 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
+	"slices"
 	"strings"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 func PrintClientHelp() {
 	fmt.Println("Possible commands:")
+	fmt.Println("* lobby [create <room> <maxPlayers> | join <room> | ready]")
+	fmt.Println("    example:")
+	fmt.Println("    lobby create fridaynight 4")
+	fmt.Println("* join [room]")
+	fmt.Println("    example:")
+	fmt.Println("    join fridaynight")
 	fmt.Println("* move <location> <unitID> <unitID> <unitID>...")
 	fmt.Println("    example:")
 	fmt.Println("    move asia 1")
 	fmt.Println("* spawn <location> <rank>")
 	fmt.Println("    example:")
 	fmt.Println("    spawn europe infantry")
-	fmt.Println("* status")
+	fmt.Println("    costs: infantry 10, cavalry 30, artillery 50 gold")
+	fmt.Println("* fight | retreat <location> | surrender | reinforce <unitID> <unitID>...")
+	fmt.Println("    decides what your units do when war is declared on them")
+	fmt.Println("    example:")
+	fmt.Println("    retreat europe")
+	fmt.Println("* combat <power|dice> [rules.json]")
+	fmt.Println("    example:")
+	fmt.Println("    combat dice")
+	fmt.Println("* status [enemies]")
+	fmt.Println("* map [plain|list|json]")
+	fmt.Println("* intel <player>")
+	fmt.Println("    example:")
+	fmt.Println("    intel washington")
+	fmt.Println("* diplomacy [propose <player> <alliance|pact|ceasefire> [duration] | accept <player> | reject <player> | break <player>]")
+	fmt.Println("    example:")
+	fmt.Println("    diplomacy propose washington ceasefire 10m")
+	fmt.Println("* say <message>")
+	fmt.Println("* whisper <player> <message>")
+	fmt.Println("    example:")
+	fmt.Println("    whisper washington meet me in europe")
+	fmt.Println("* team join <name> | team invite <player> | team leave | team <message>")
 	fmt.Println("* spam <n>")
 	fmt.Println("    example:")
 	fmt.Println("    spam 5")
+	fmt.Println("* login <password>")
+	fmt.Println("    signs in again after the session ended")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -33,6 +64,10 @@ func ClientWelcome() (string, error) {
 		return "", errors.New("you must enter a username. goodbye")
 	}
 	username := words[0]
+	err := routing.ValidateName(username)
+	if err != nil {
+		return "", fmt.Errorf("%v. goodbye", err)
+	}
 	fmt.Printf("Welcome, %s!\n", username)
 	PrintClientHelp()
 	return username, nil
@@ -40,8 +75,25 @@ func ClientWelcome() (string, error) {
 
 func PrintServerHelp() {
 	fmt.Println("Possible commands:")
-	fmt.Println("* pause")
-	fmt.Println("* resume")
+	fmt.Println("* create <room>")
+	fmt.Println("* rooms")
+	fmt.Println("* players (or list)")
+	fmt.Println("* kick <player> [reason]")
+	fmt.Println("* ban <player> [reason]")
+	fmt.Println("* unban <player>")
+	fmt.Println("* broadcast <message>")
+	fmt.Println("    example:")
+	fmt.Println("    broadcast pizza is here, 10 minute break")
+	fmt.Println("* reset <room>")
+	fmt.Println("* chatlog [on | off]")
+	fmt.Println("* signing [on | off]")
+	fmt.Println("* pause [room <room> | player <player>] [duration] [reason]")
+	fmt.Println("    example:")
+	fmt.Println("    pause player washington 5m connection trouble")
+	fmt.Println("* resume [room <room> | player <player>]")
+	fmt.Println("* victory <room> [elimination | control <locations> <turns> | score <minutes>]")
+	fmt.Println("    example:")
+	fmt.Println("    victory default control 4 3")
 	fmt.Println("* quit")
 	fmt.Println("* help")
 }
@@ -77,8 +129,9 @@ func PrintQuit() {
 }
 
 func (gs *GameState) CommandStatus() {
-	if gs.isPaused() {
+	if ps := gs.getPause(); ps.IsPaused {
 		fmt.Println("The game is paused.")
+		printPause(ps)
 		return
 	} else {
 		fmt.Println("The game is not paused.")
@@ -86,7 +139,18 @@ func (gs *GameState) CommandStatus() {
 
 	p := gs.GetPlayerSnap()
 	fmt.Printf("You are %s, and you have %d units.\n", p.Username, len(p.Units))
+	fmt.Printf("Your treasury holds %v gold.\n", gs.getTreasury())
+	fmt.Printf("You chose the %s combat model, wars only use it if your opponent did too.\n", gs.getCombatModel())
+	if home := gs.getHome(); home != "" {
+		fmt.Printf("Your home base is %s.\n", home)
+	}
+	outOfSupply := gs.getOutOfSupply()
 	for _, unit := range p.Units {
-		fmt.Printf("* %v: %v, %v\n", unit.ID, unit.Location, unit.Rank)
+		fmt.Printf("* %v: %v, %v (%v/%v HP, %v XP)", unit.ID, unit.Location, unit.Title(), unit.health(), unit.MaxHP(), unit.XP)
+		if slices.Contains(outOfSupply, unit.Location) {
+			fmt.Print(", out of supply")
+		}
+		fmt.Println()
 	}
+	gs.printEnemyPositions()
 }
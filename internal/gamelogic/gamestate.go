@@ -2,12 +2,52 @@ package gamelogic
 
 import (
 	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
 type GameState struct {
 	Player Player
 	Paused bool
-	mu     *sync.RWMutex
+	// reason and expiry of the current pause
+	pause routing.PlayingState
+	// room the player joined, every routing key is scoped to it
+	room string
+	// team the player chats with
+	team string
+	// home base the server traces the player's supply to, and the
+	// locations it found cut off from it
+	home        Location
+	outOfSupply []Location
+	// mirror of who the server says controls each location
+	territories map[Location]Territory
+	// session token the server handed out at login
+	token string
+	// mirror of the balance the server keeps for this player
+	treasury   int
+	nextUnitID int
+	// model used for the wars this player recognizes
+	combatModel CombatModel
+	combatRules CombatConfig
+	// last units seen per opponent and location
+	intel map[string]map[Location]Sighting
+	// wars the defender is waiting on the attacker to resolve, and
+	// resolutions that overtook the recognition they resolve
+	pendingWars     map[string]RecognitionOfWar
+	heldResolutions map[string]WarResolution
+	// wars the attacker is waiting to fight, wars waiting on the player's
+	// decision and the decisions it sent, applied once the attacker's
+	// resolution accepts them
+	openWars      map[string]openWar
+	undecidedWars map[string]RecognitionOfWar
+	sentDecisions map[string]WarDecision
+	// applied resolutions and the participants whose ack is still missing
+	unconfirmedWars map[string]unconfirmedWar
+	// treaties per other player and diplomatic proposals waiting on an answer
+	treaties          map[string]Treaty
+	proposalsSent     map[string]DiplomacyMessage
+	proposalsReceived map[string]DiplomacyMessage
+	mu                *sync.RWMutex
 }
 
 func NewGameState(username string) *GameState {
@@ -16,27 +56,109 @@ func NewGameState(username string) *GameState {
 			Username: username,
 			Units:    map[int]Unit{},
 		},
-		Paused: false,
-		mu:     &sync.RWMutex{},
+		Paused:            false,
+		treasury:          StartingTreasury,
+		nextUnitID:        1,
+		combatModel:       CombatModelPower,
+		combatRules:       DefaultCombatConfig(),
+		intel:             map[string]map[Location]Sighting{},
+		territories:       map[Location]Territory{},
+		pendingWars:       map[string]RecognitionOfWar{},
+		heldResolutions:   map[string]WarResolution{},
+		openWars:          map[string]openWar{},
+		undecidedWars:     map[string]RecognitionOfWar{},
+		sentDecisions:     map[string]WarDecision{},
+		unconfirmedWars:   map[string]unconfirmedWar{},
+		treaties:          map[string]Treaty{},
+		proposalsSent:     map[string]DiplomacyMessage{},
+		proposalsReceived: map[string]DiplomacyMessage{},
+		mu:                &sync.RWMutex{},
 	}
 }
 
 func (gs *GameState) resumeGame() {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-	gs.Paused = false
+	gs.setPause(routing.PlayingState{})
 }
 
 func (gs *GameState) pauseGame() {
+	gs.setPause(routing.PlayingState{IsPaused: true})
+}
+
+func (gs *GameState) setPause(ps routing.PlayingState) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	gs.Paused = true
+	gs.Paused = ps.IsPaused
+	gs.pause = ps
+}
+
+// getPause returns the current pause, a timed pause that ran out counts as resumed.
+func (gs *GameState) getPause() routing.PlayingState {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	if !gs.Paused || gs.pause.Expired() {
+		return routing.PlayingState{}
+	}
+	return gs.pause
 }
 
 func (gs *GameState) isPaused() bool {
+	return gs.getPause().IsPaused
+}
+
+func (gs *GameState) setCombatModel(model CombatModel) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.combatModel = model
+}
+
+func (gs *GameState) getCombatModel() CombatModel {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.combatModel
+}
+
+func (gs *GameState) setCombatRules(rules CombatConfig) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.combatRules = rules
+}
+
+func (gs *GameState) getCombatRules() CombatConfig {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.combatRules
+}
+
+func (gs *GameState) getTreasury() int {
 	gs.mu.RLock()
 	defer gs.mu.RUnlock()
-	return gs.Paused
+	return gs.treasury
+}
+
+func (gs *GameState) setTreasury(balance int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.treasury = balance
+}
+
+// spend deducts cost from the treasury if the player can afford it.
+func (gs *GameState) spend(cost int) bool {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	if gs.treasury < cost {
+		return false
+	}
+	gs.treasury -= cost
+	return true
+}
+
+// newUnitID never hands out the same ID twice, even after units are killed.
+func (gs *GameState) newUnitID() int {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	id := gs.nextUnitID
+	gs.nextUnitID++
+	return id
 }
 
 func (gs *GameState) addUnit(u Unit) {
@@ -45,14 +167,83 @@ func (gs *GameState) addUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
-func (gs *GameState) removeUnitsInLocation(loc Location) {
+func (gs *GameState) removeUnits(ids []int) {
 	gs.mu.Lock()
 	defer gs.mu.Unlock()
-	for k, v := range gs.Player.Units {
-		if v.Location == loc {
-			delete(gs.Player.Units, k)
+	for _, id := range ids {
+		delete(gs.Player.Units, id)
+	}
+}
+
+func (gs *GameState) addPendingWar(rw RecognitionOfWar) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.pendingWars[rw.ID] = rw
+}
+
+func (gs *GameState) popPendingWar(id string) (RecognitionOfWar, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	rw, ok := gs.pendingWars[id]
+	delete(gs.pendingWars, id)
+	return rw, ok
+}
+
+func (gs *GameState) holdResolution(res WarResolution) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.heldResolutions[res.WarID] = res
+}
+
+// PopHeldResolution returns the resolution of a war that arrived before its
+// recognition, if there is one.
+func (gs *GameState) PopHeldResolution(id string) (WarResolution, bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	res, ok := gs.heldResolutions[id]
+	delete(gs.heldResolutions, id)
+	return res, ok
+}
+
+type unconfirmedWar struct {
+	res     WarResolution
+	waiting map[string]struct{}
+}
+
+// addUnconfirmedWar waits for every other participant of the war to ack it.
+func (gs *GameState) addUnconfirmedWar(res WarResolution) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	waiting := map[string]struct{}{}
+	for _, username := range res.Participants() {
+		if username != gs.Player.Username {
+			waiting[username] = struct{}{}
 		}
 	}
+	gs.unconfirmedWars[res.WarID] = unconfirmedWar{
+		res:     res,
+		waiting: waiting,
+	}
+}
+
+// ackUnconfirmedWar records username's ack. The war is forgotten once
+// everyone acked, done reports whether that happened.
+func (gs *GameState) ackUnconfirmedWar(id, username string) (res WarResolution, done bool, ok bool) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	war, ok := gs.unconfirmedWars[id]
+	if !ok {
+		return WarResolution{}, false, false
+	}
+	if _, waiting := war.waiting[username]; !waiting {
+		return WarResolution{}, false, false
+	}
+	delete(war.waiting, username)
+	if len(war.waiting) == 0 {
+		delete(gs.unconfirmedWars, id)
+		return war.res, true, true
+	}
+	return war.res, false, true
 }
 
 func (gs *GameState) UpdateUnit(u Unit) {
@@ -61,6 +252,50 @@ func (gs *GameState) UpdateUnit(u Unit) {
 	gs.Player.Units[u.ID] = u
 }
 
+func (gs *GameState) GetRoom() string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.room
+}
+
+func (gs *GameState) setRoom(room string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.room = room
+}
+
+func (gs *GameState) GetTeam() string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.team
+}
+
+func (gs *GameState) setTeam(team string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.team = team
+}
+
+func (gs *GameState) SetToken(token string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.token = token
+}
+
+func (gs *GameState) getToken() string {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.token
+}
+
+// GetPlayerState is the player's full state as reported to the server.
+func (gs *GameState) GetPlayerState() PlayerState {
+	return PlayerState{
+		Token:  gs.getToken(),
+		Player: gs.GetPlayerSnap(),
+	}
+}
+
 func (gs *GameState) GetUsername() string {
 	return gs.Player.Username
 }
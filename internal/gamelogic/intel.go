@@ -0,0 +1,182 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Sighting is the last known state of an opponent's units in a location.
+type Sighting struct {
+	Units  []Unit
+	SeenAt time.Time
+}
+
+// CommandIntel shows everything known about a single opponent.
+func (gs *GameState) CommandIntel(words []string) error {
+	if len(words) < 2 {
+		return errors.New("usage: intel <player>")
+	}
+	username := words[1]
+	intel, ok := gs.getIntelSnap()[username]
+	if !ok {
+		return fmt.Errorf("error: no intel on %s", username)
+	}
+	printIntel(username, intel)
+	return nil
+}
+
+// CommandStatusEnemies shows everything known about every opponent.
+func (gs *GameState) CommandStatusEnemies() {
+	intel := gs.getIntelSnap()
+	if len(intel) == 0 {
+		fmt.Println("You have no intel on any opponent.")
+		return
+	}
+	usernames := []string{}
+	for username := range intel {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		printIntel(username, intel[username])
+	}
+}
+
+// recordSighting remembers the units an opponent has in a location. A
+// sighting always carries every unit the opponent has there, so it replaces
+// what was known about that location, and units seen there are gone from
+// wherever they were seen before.
+func (gs *GameState) recordSighting(p Player, loc Location) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	sightings, ok := gs.intel[p.Username]
+	if !ok {
+		sightings = map[Location]Sighting{}
+		gs.intel[p.Username] = sightings
+	}
+	units := unitsInLocation(p, loc)
+	for _, unit := range units {
+		for l, sighting := range sightings {
+			sighting.Units = withoutUnit(sighting.Units, unit.ID)
+			sightings[l] = sighting
+		}
+	}
+	sightings[loc] = Sighting{
+		Units:  units,
+		SeenAt: time.Now(),
+	}
+}
+
+func (gs *GameState) forgetEnemyUnits(username string, ids []int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	sightings := gs.intel[username]
+	for _, id := range ids {
+		for loc, sighting := range sightings {
+			sighting.Units = withoutUnit(sighting.Units, id)
+			sightings[loc] = sighting
+		}
+	}
+}
+
+func (gs *GameState) forgetPlayer(username string) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	delete(gs.intel, username)
+}
+
+func (gs *GameState) getIntelSnap() map[string]map[Location]Sighting {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	snap := map[string]map[Location]Sighting{}
+	for username, sightings := range gs.intel {
+		snap[username] = map[Location]Sighting{}
+		for loc, sighting := range sightings {
+			snap[username][loc] = Sighting{
+				Units:  append([]Unit{}, sighting.Units...),
+				SeenAt: sighting.SeenAt,
+			}
+		}
+	}
+	return snap
+}
+
+func (gs *GameState) printEnemyPositions() {
+	intel := gs.getIntelSnap()
+	fmt.Println("Known enemy positions:")
+	usernames := []string{}
+	for username := range intel {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	known := false
+	for _, username := range usernames {
+		sightings := intel[username]
+		locations := []Location{}
+		for loc := range sightings {
+			locations = append(locations, loc)
+		}
+		sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+		for _, loc := range locations {
+			if len(sightings[loc].Units) > 0 {
+				known = true
+				fmt.Printf("* %s: %v unit(s) in %s\n", username, len(sightings[loc].Units), loc)
+			}
+		}
+	}
+	if !known {
+		fmt.Println("* none")
+	}
+}
+
+func (gs *GameState) printEnemyPositionsOf(username string) {
+	printIntel(username, gs.getIntelSnap()[username])
+}
+
+func printIntel(username string, sightings map[Location]Sighting) {
+	fmt.Printf("Intel on %s:\n", username)
+	locations := []Location{}
+	for loc := range sightings {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+	if len(locations) == 0 {
+		fmt.Println("* none")
+	}
+	for _, loc := range locations {
+		sighting := sightings[loc]
+		age := time.Since(sighting.SeenAt).Round(time.Second)
+		fmt.Printf("* %s: %s (seen %v ago)\n", loc, describeRanks(sighting.Units), age)
+	}
+}
+
+// describeRanks summarizes units as counts per rank, e.g. "2 infantry, 1 cavalry".
+func describeRanks(units []Unit) string {
+	if len(units) == 0 {
+		return "no units"
+	}
+	counts := map[UnitRank]int{}
+	for _, unit := range units {
+		counts[unit.Rank]++
+	}
+	parts := []string{}
+	for _, rank := range []UnitRank{RankInfantry, RankCavalry, RankArtillery} {
+		if counts[rank] > 0 {
+			parts = append(parts, fmt.Sprintf("%v %s", counts[rank], rank))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func withoutUnit(units []Unit, id int) []Unit {
+	kept := []Unit{}
+	for _, unit := range units {
+		if unit.ID != id {
+			kept = append(kept, unit)
+		}
+	}
+	return kept
+}
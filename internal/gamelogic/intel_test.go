@@ -0,0 +1,68 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordSightingFollowsUnits(t *testing.T) {
+	gs := NewGameState("alice")
+	bob := Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankCavalry, Location: "europe"},
+	}}
+	gs.recordSighting(bob, "europe")
+
+	// the cavalry moved on to asia
+	bob.Units[2] = Unit{ID: 2, Rank: RankCavalry, Location: "asia"}
+	gs.recordSighting(bob, "asia")
+
+	intel := gs.getIntelSnap()["bob"]
+	if got := intel["europe"].Units; !reflect.DeepEqual(got, []Unit{bob.Units[1]}) {
+		t.Errorf("expected only the infantry in europe, got %v", got)
+	}
+	if got := intel["asia"].Units; !reflect.DeepEqual(got, []Unit{bob.Units[2]}) {
+		t.Errorf("expected the cavalry in asia, got %v", got)
+	}
+
+	// a later sighting of europe replaces what was known there
+	delete(bob.Units, 1)
+	gs.recordSighting(bob, "europe")
+	if got := gs.getIntelSnap()["bob"]["europe"].Units; len(got) != 0 {
+		t.Errorf("expected europe to be empty, got %v", got)
+	}
+}
+
+func TestForgettingEnemies(t *testing.T) {
+	gs := NewGameState("alice")
+	bob := Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "europe"},
+	}}
+	gs.recordSighting(bob, "europe")
+
+	gs.forgetEnemyUnits("bob", []int{1})
+	if got := gs.getIntelSnap()["bob"]["europe"].Units; !reflect.DeepEqual(got, []Unit{bob.Units[2]}) {
+		t.Errorf("expected only unit 2 to be known, got %v", got)
+	}
+
+	gs.forgetPlayer("bob")
+	if _, ok := gs.getIntelSnap()["bob"]; ok {
+		t.Error("expected nothing to be known about bob")
+	}
+}
+
+func TestDescribeRanks(t *testing.T) {
+	cases := []struct {
+		units []Unit
+		want  string
+	}{
+		{nil, "no units"},
+		{[]Unit{{Rank: RankArtillery}, {Rank: RankInfantry}, {Rank: RankInfantry}}, "2 infantry, 1 artillery"},
+	}
+	for _, c := range cases {
+		if got := describeRanks(c.units); got != c.want {
+			t.Errorf("expected %q, got %q", c.want, got)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type LobbyAction string
+
+const (
+	LobbyActionAnnounce = "announce"
+	LobbyActionList     = "list"
+	LobbyActionCreate   = "create"
+	LobbyActionJoin     = "join"
+	LobbyActionReady    = "ready"
+	LobbyActionStarted  = "started"
+)
+
+// minPlayersToStart is how many ready players a lobby game needs to start.
+const minPlayersToStart = 2
+
+type LobbyRequest struct {
+	Username   string
+	Token      string
+	Action     LobbyAction
+	Room       string
+	MaxPlayers int
+}
+
+type OpenGame struct {
+	Room       string
+	MaxPlayers int
+	Players    []string
+	Ready      []string
+}
+
+type LobbyResponse struct {
+	Action  LobbyAction
+	Room    string
+	Message string
+	Online  []string
+	Games   []OpenGame
+}
+
+// Lobby is run by the server. It tracks who is online and the games waiting
+// for players, and decides when a game starts.
+type Lobby struct {
+	online map[string]struct{}
+	games  map[string]*OpenGame
+	// reports whether the server already runs a game in a room
+	hosted func(room string) bool
+	mu     *sync.Mutex
+}
+
+func NewLobby(hosted func(room string) bool) *Lobby {
+	return &Lobby{
+		online: map[string]struct{}{},
+		games:  map[string]*OpenGame{},
+		hosted: hosted,
+		mu:     &sync.Mutex{},
+	}
+}
+
+// CommandLobby turns the client's lobby command into a request for the server.
+func (gs *GameState) CommandLobby(words []string) (LobbyRequest, error) {
+	req := gs.NewLobbyRequest(LobbyActionList)
+	if len(words) < 2 {
+		return req, nil
+	}
+	if gs.GetRoom() != "" {
+		return LobbyRequest{}, fmt.Errorf("error: you already joined room %s", gs.GetRoom())
+	}
+	switch words[1] {
+	case LobbyActionCreate:
+		if len(words) < 4 {
+			return LobbyRequest{}, errors.New("usage: lobby create <room> <maxPlayers>")
+		}
+		maxPlayers, err := strconv.Atoi(words[3])
+		if err != nil || maxPlayers < minPlayersToStart {
+			return LobbyRequest{}, fmt.Errorf("error: a game needs room for at least %v players", minPlayersToStart)
+		}
+		req.Action = LobbyActionCreate
+		req.Room = words[2]
+		req.MaxPlayers = maxPlayers
+	case LobbyActionJoin:
+		if len(words) < 3 {
+			return LobbyRequest{}, errors.New("usage: lobby join <room>")
+		}
+		req.Action = LobbyActionJoin
+		req.Room = words[2]
+	case LobbyActionReady:
+		req.Action = LobbyActionReady
+	default:
+		return LobbyRequest{}, errors.New("usage: lobby [create <room> <maxPlayers> | join <room> | ready]")
+	}
+	if req.Room != "" {
+		err := routing.ValidateRoom(req.Room)
+		if err != nil {
+			return LobbyRequest{}, err
+		}
+	}
+	return req, nil
+}
+
+func (gs *GameState) NewLobbyRequest(action LobbyAction) LobbyRequest {
+	return LobbyRequest{
+		Username: gs.GetUsername(),
+		Token:    gs.getToken(),
+		Action:   action,
+	}
+}
+
+// HandleLobbyResponse shows the lobby's answer. When the game the player is
+// in starts it joins its room and returns true, so the caller can subscribe.
+func (gs *GameState) HandleLobbyResponse(resp LobbyResponse) bool {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Lobby ====")
+	if resp.Message != "" {
+		fmt.Println(resp.Message)
+	}
+	if resp.Action == LobbyActionList {
+		fmt.Printf("Online: %v\n", resp.Online)
+		if len(resp.Games) == 0 {
+			fmt.Println("No open games.")
+		}
+		for _, game := range resp.Games {
+			fmt.Printf("* %s: %v/%v players %v, ready %v\n", game.Room, len(game.Players), game.MaxPlayers, game.Players, game.Ready)
+		}
+	}
+	if resp.Action != LobbyActionStarted {
+		return false
+	}
+	// the server created the room before it started the game
+	err := gs.JoinRoom(resp.Room)
+	if err != nil {
+		fmt.Println(err)
+		return false
+	}
+	return true
+}
+
+// HandleRequest applies a lobby request and returns the responses to send
+// to each player. When a game fills up with ready players its room is
+// returned so the server can start it.
+func (l *Lobby) HandleRequest(req LobbyRequest) (responses map[string]LobbyResponse, start string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.online[req.Username] = struct{}{}
+	responses = map[string]LobbyResponse{}
+	reply := func(message string) {
+		responses[req.Username] = LobbyResponse{Action: req.Action, Room: req.Room, Message: message}
+	}
+
+	switch req.Action {
+	case LobbyActionAnnounce:
+		reply(fmt.Sprintf("Welcome to the lobby, %s!", req.Username))
+	case LobbyActionList:
+		responses[req.Username] = LobbyResponse{
+			Action: LobbyActionList,
+			Online: l.onlineSnap(),
+			Games:  l.gamesSnap(),
+		}
+	case LobbyActionCreate:
+		if err := routing.ValidateRoom(req.Room); err != nil {
+			reply(err.Error())
+			break
+		}
+		if req.MaxPlayers < minPlayersToStart {
+			reply(fmt.Sprintf("A game needs room for at least %v players.", minPlayersToStart))
+			break
+		}
+		if _, ok := l.games[req.Room]; ok {
+			reply(fmt.Sprintf("Game %s already exists.", req.Room))
+			break
+		}
+		if l.hosted(req.Room) {
+			reply(fmt.Sprintf("A game is already running in room %s.", req.Room))
+			break
+		}
+		l.leaveGames(req.Username)
+		l.games[req.Room] = &OpenGame{
+			Room:       req.Room,
+			MaxPlayers: req.MaxPlayers,
+			Players:    []string{req.Username},
+		}
+		reply(fmt.Sprintf("Created game %s for up to %v players.", req.Room, req.MaxPlayers))
+	case LobbyActionJoin:
+		game, ok := l.games[req.Room]
+		if !ok {
+			reply(fmt.Sprintf("Game %s does not exist.", req.Room))
+			break
+		}
+		if slices.Contains(game.Players, req.Username) {
+			reply(fmt.Sprintf("You are already in game %s.", req.Room))
+			break
+		}
+		if len(game.Players) >= game.MaxPlayers {
+			reply(fmt.Sprintf("Game %s is full.", req.Room))
+			break
+		}
+		l.leaveGames(req.Username)
+		game.Players = append(game.Players, req.Username)
+		for _, username := range game.Players {
+			responses[username] = LobbyResponse{
+				Action:  LobbyActionJoin,
+				Room:    game.Room,
+				Message: fmt.Sprintf("%s joined game %s (%v/%v).", req.Username, game.Room, len(game.Players), game.MaxPlayers),
+			}
+		}
+	case LobbyActionReady:
+		game := l.gameOf(req.Username)
+		if game == nil {
+			reply("You are not in a game.")
+			break
+		}
+		if !slices.Contains(game.Ready, req.Username) {
+			game.Ready = append(game.Ready, req.Username)
+		}
+		started := len(game.Ready) == len(game.Players) && len(game.Players) >= minPlayersToStart
+		// the room may have been created by hand since the game was opened
+		if started && l.hosted(game.Room) {
+			for _, username := range game.Players {
+				responses[username] = LobbyResponse{
+					Action:  LobbyActionReady,
+					Room:    game.Room,
+					Message: fmt.Sprintf("Game %s can not start, a game is already running in its room.", game.Room),
+				}
+			}
+			delete(l.games, game.Room)
+			break
+		}
+		for _, username := range game.Players {
+			resp := LobbyResponse{
+				Action:  LobbyActionReady,
+				Room:    game.Room,
+				Message: fmt.Sprintf("%s is ready (%v/%v).", req.Username, len(game.Ready), len(game.Players)),
+			}
+			if started {
+				resp.Action = LobbyActionStarted
+				resp.Message = fmt.Sprintf("Game %s is starting!", game.Room)
+			}
+			responses[username] = resp
+		}
+		if started {
+			delete(l.games, game.Room)
+			start = game.Room
+		}
+	default:
+		reply(fmt.Sprintf("Unknown lobby action %s.", req.Action))
+	}
+	return responses, start
+}
+
+// leaveGames must be called with the lock held.
+func (l *Lobby) leaveGames(username string) {
+	for room, game := range l.games {
+		game.Players = without(game.Players, username)
+		game.Ready = without(game.Ready, username)
+		if len(game.Players) == 0 {
+			delete(l.games, room)
+		}
+	}
+}
+
+// gameOf must be called with the lock held.
+func (l *Lobby) gameOf(username string) *OpenGame {
+	for _, game := range l.games {
+		if slices.Contains(game.Players, username) {
+			return game
+		}
+	}
+	return nil
+}
+
+// onlineSnap must be called with the lock held.
+func (l *Lobby) onlineSnap() []string {
+	online := []string{}
+	for username := range l.online {
+		online = append(online, username)
+	}
+	sort.Strings(online)
+	return online
+}
+
+// gamesSnap must be called with the lock held.
+func (l *Lobby) gamesSnap() []OpenGame {
+	games := []OpenGame{}
+	for _, game := range l.games {
+		games = append(games, OpenGame{
+			Room:       game.Room,
+			MaxPlayers: game.MaxPlayers,
+			Players:    append([]string{}, game.Players...),
+			Ready:      append([]string{}, game.Ready...),
+		})
+	}
+	sort.Slice(games, func(i, j int) bool { return games[i].Room < games[j].Room })
+	return games
+}
+
+func without(values []string, value string) []string {
+	kept := []string{}
+	for _, v := range values {
+		if v != value {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
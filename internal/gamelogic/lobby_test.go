@@ -0,0 +1,76 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+)
+
+func newTestLobby(hosted ...string) *Lobby {
+	return NewLobby(func(room string) bool {
+		for _, h := range hosted {
+			if h == room {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func TestLobbyStartsFullReadyGame(t *testing.T) {
+	l := newTestLobby()
+	l.HandleRequest(LobbyRequest{Username: "alice", Action: LobbyActionCreate, Room: "r1", MaxPlayers: 2})
+	l.HandleRequest(LobbyRequest{Username: "bob", Action: LobbyActionJoin, Room: "r1"})
+
+	responses, _ := l.HandleRequest(LobbyRequest{Username: "carol", Action: LobbyActionJoin, Room: "r1"})
+	if !strings.Contains(responses["carol"].Message, "full") {
+		t.Errorf("expected carol to find r1 full, got %q", responses["carol"].Message)
+	}
+
+	_, start := l.HandleRequest(LobbyRequest{Username: "alice", Action: LobbyActionReady})
+	if start != "" {
+		t.Fatalf("expected r1 to wait for bob, got %q", start)
+	}
+	responses, start = l.HandleRequest(LobbyRequest{Username: "bob", Action: LobbyActionReady})
+	if start != "r1" {
+		t.Fatalf("expected r1 to start, got %q", start)
+	}
+	for _, username := range []string{"alice", "bob"} {
+		if responses[username].Action != LobbyActionStarted {
+			t.Errorf("expected %s to be told r1 started, got %+v", username, responses[username])
+		}
+	}
+}
+
+func TestLobbyEnforcesMaxPlayersOnServer(t *testing.T) {
+	l := newTestLobby()
+	// a modified client skips the check in CommandLobby
+	responses, _ := l.HandleRequest(LobbyRequest{Username: "alice", Action: LobbyActionCreate, Room: "r1", MaxPlayers: 1})
+	if strings.HasPrefix(responses["alice"].Message, "Created") {
+		t.Fatalf("expected a one player game to be refused, got %q", responses["alice"].Message)
+	}
+	if games := l.gamesSnap(); len(games) != 0 {
+		t.Errorf("expected no open games, got %v", games)
+	}
+}
+
+func TestLobbyRefusesRunningRoom(t *testing.T) {
+	l := newTestLobby("default")
+	responses, _ := l.HandleRequest(LobbyRequest{Username: "alice", Action: LobbyActionCreate, Room: "default", MaxPlayers: 2})
+	if !strings.Contains(responses["alice"].Message, "already running") {
+		t.Errorf("expected the running room to be refused, got %q", responses["alice"].Message)
+	}
+}
+
+func TestLobbyDoesNotStartInRoomCreatedMeanwhile(t *testing.T) {
+	hosted := false
+	l := NewLobby(func(string) bool { return hosted })
+	l.HandleRequest(LobbyRequest{Username: "alice", Action: LobbyActionCreate, Room: "r1", MaxPlayers: 2})
+	l.HandleRequest(LobbyRequest{Username: "bob", Action: LobbyActionJoin, Room: "r1"})
+	l.HandleRequest(LobbyRequest{Username: "alice", Action: LobbyActionReady})
+
+	hosted = true
+	_, start := l.HandleRequest(LobbyRequest{Username: "bob", Action: LobbyActionReady})
+	if start != "" {
+		t.Errorf("expected r1 not to start, got %q", start)
+	}
+}
@@ -3,6 +3,7 @@ package gamelogic
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"strconv"
 )
 
@@ -20,6 +21,10 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 
 	fmt.Println()
 	fmt.Println("==== Move Detected ====")
+	if player.Username != move.Player.Username && !canSee(player, move.ToLocation) {
+		fmt.Printf("%s is moving units somewhere you can't see.\n", move.Player.Username)
+		return MoveOutComeSafe
+	}
 	fmt.Printf("%s is moving %v unit(s) to %s\n", move.Player.Username, len(move.Units), move.ToLocation)
 	for _, unit := range move.Units {
 		fmt.Printf("* %v\n", unit.Rank)
@@ -29,15 +34,46 @@ func (gs *GameState) HandleMove(move ArmyMove) MoveOutcome {
 		return MoveOutcomeSamePlayer
 	}
 
+	gs.recordSighting(move.Player, move.ToLocation)
+	gs.printEnemyPositionsOf(move.Player.Username)
+
 	overlappingLocation := getOverlappingLocation(player, move.Player)
+	if overlappingLocation != "" && gs.atPeaceWith(move.Player.Username) {
+		treaty, _ := gs.getTreaty(move.Player.Username)
+		fmt.Printf("You share %s with %s, but your %s keeps the peace.\n", overlappingLocation, move.Player.Username, treaty.Kind)
+		return MoveOutComeSafe
+	}
 	if overlappingLocation != "" {
-		fmt.Printf("You have units in %s! You are at war with %s!\n", overlappingLocation, move.Player.Username)
+		fmt.Printf("You have units in %s! %s attacks!\n", overlappingLocation, move.Player.Username)
 		return MoveOutcomeMakeWar
 	}
 	fmt.Printf("You are safe from %s's units.\n", move.Player.Username)
 	return MoveOutComeSafe
 }
 
+// HandleMove moves the units of a move the server relays in its records.
+// Units it does not know about yet are left to the player's next report.
+func (w *World) HandleMove(mv ArmyMove) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := getAllLocations()[mv.ToLocation]; !ok {
+		return
+	}
+	p, ok := w.players[mv.Player.Username]
+	if !ok {
+		return
+	}
+	units := maps.Clone(p.Units)
+	for _, unit := range mv.Units {
+		if known, ok := units[unit.ID]; ok {
+			known.Location = mv.ToLocation
+			units[unit.ID] = known
+		}
+	}
+	p.Units = units
+	w.players[p.Username] = p
+}
+
 func getOverlappingLocation(p1 Player, p2 Player) Location {
 	for _, u1 := range p1.Units {
 		for _, u2 := range p2.Units {
@@ -82,10 +118,13 @@ func (gs *GameState) CommandMove(words []string) (ArmyMove, error) {
 		newUnits = append(newUnits, unit)
 	}
 
+	// only the units in the destination are broadcast, the rest stay hidden
 	mv := ArmyMove{
 		ToLocation: newLocation,
 		Units:      newUnits,
-		Player:     gs.GetPlayerSnap(),
+		Player:     playerInLocation(gs.GetPlayerSnap(), newLocation),
+		Model:      gs.getCombatModel(),
+		Rules:      gs.getCombatRules(),
 	}
 	fmt.Printf("Moved %v units to %s\n", len(mv.Units), mv.ToLocation)
 	return mv, nil
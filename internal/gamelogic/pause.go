@@ -1,19 +1,81 @@
 package gamelogic
 
 import (
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
 )
 
+// PauseCommand is a parsed server pause or resume. An empty Room and Player
+// targets every room.
+type PauseCommand struct {
+	Room   string
+	Player string
+	State  routing.PlayingState
+}
+
 func (gs *GameState) HandlePause(ps routing.PlayingState) {
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	if ps.IsPaused {
 		fmt.Println("==== Pause Detected ====")
-		gs.pauseGame()
+		gs.setPause(ps)
+		printPause(ps)
 	} else {
 		fmt.Println("==== Resume Detected ====")
 		gs.resumeGame()
+		if ps.Reason != "" {
+			fmt.Println(ps.Reason)
+		}
+	}
+}
+
+func printPause(ps routing.PlayingState) {
+	if ps.Reason != "" {
+		fmt.Printf("Reason: %s\n", ps.Reason)
+	}
+	if !ps.Expiry.IsZero() {
+		fmt.Printf("The game resumes at %s (in %v).\n", ps.Expiry.Format(time.Kitchen), time.Until(ps.Expiry).Round(time.Second))
+	}
+}
+
+// ParsePauseCommand reads the server's pause and resume commands:
+// pause [room <room> | player <player>] [duration] [reason...] and
+// resume [room <room> | player <player>].
+func ParsePauseCommand(words []string) (PauseCommand, error) {
+	if len(words) == 0 || (words[0] != "pause" && words[0] != "resume") {
+		return PauseCommand{}, errors.New("usage: pause [room <room> | player <player>] [duration] [reason] | resume [room <room> | player <player>]")
+	}
+	cmd := PauseCommand{
+		State: routing.PlayingState{IsPaused: words[0] == "pause"},
+	}
+	rest := words[1:]
+	if len(rest) > 0 && (rest[0] == "room" || rest[0] == "player") {
+		if len(rest) < 2 {
+			return PauseCommand{}, fmt.Errorf("usage: %s %s <name>", words[0], rest[0])
+		}
+		if rest[0] == "room" {
+			cmd.Room = rest[1]
+		} else {
+			cmd.Player = rest[1]
+		}
+		rest = rest[2:]
+	}
+	if !cmd.State.IsPaused {
+		return cmd, nil
+	}
+	if len(rest) > 0 {
+		if d, err := time.ParseDuration(rest[0]); err == nil {
+			if d <= 0 {
+				return PauseCommand{}, fmt.Errorf("error: %s is not a valid pause duration", rest[0])
+			}
+			cmd.State.Expiry = time.Now().Add(d)
+			rest = rest[1:]
+		}
 	}
+	cmd.State.Reason = strings.Join(rest, " ")
+	return cmd, nil
 }
@@ -0,0 +1,54 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePauseCommand(t *testing.T) {
+	cases := []struct {
+		input  string
+		room   string
+		player string
+		paused bool
+		timed  bool
+		reason string
+		err    bool
+	}{
+		{input: "pause", paused: true},
+		{input: "pause 5m lunch break", paused: true, timed: true, reason: "lunch break"},
+		{input: "pause room r1 maintenance", room: "r1", paused: true, reason: "maintenance"},
+		{input: "pause player bob 30s", player: "bob", paused: true, timed: true},
+		{input: "resume room r1", room: "r1"},
+		{input: "resume player bob ignored", player: "bob"},
+		{input: "pause room", err: true},
+		{input: "pause -5m", err: true},
+		{input: "stop", err: true},
+	}
+	for _, c := range cases {
+		cmd, err := ParsePauseCommand(strings.Fields(c.input))
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", c.input, err)
+			continue
+		}
+		if cmd.Room != c.room || cmd.Player != c.player {
+			t.Errorf("%q: expected room %q and player %q, got %q and %q", c.input, c.room, c.player, cmd.Room, cmd.Player)
+		}
+		if cmd.State.IsPaused != c.paused || cmd.State.Reason != c.reason {
+			t.Errorf("%q: expected paused %v with reason %q, got %v with %q", c.input, c.paused, c.reason, cmd.State.IsPaused, cmd.State.Reason)
+		}
+		if timed := !cmd.State.Expiry.IsZero(); timed != c.timed {
+			t.Errorf("%q: expected timed %v, got expiry %v", c.input, c.timed, cmd.State.Expiry)
+		}
+		if c.timed && !cmd.State.Expiry.After(time.Now()) {
+			t.Errorf("%q: expected the pause to expire in the future, got %v", c.input, cmd.State.Expiry)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package gamelogic
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+type PresenceKind string
+
+const (
+	PresenceJoin    = "join"
+	PresenceLeave   = "leave"
+	PresenceTimeout = "timeout"
+	PresenceKick    = "kick"
+)
+
+const (
+	HeartbeatInterval = 5 * time.Second
+	// PresenceTimeoutAfter is how long the server waits for a heartbeat
+	// before it considers a client gone.
+	PresenceTimeoutAfter = 3 * HeartbeatInterval
+)
+
+type Heartbeat struct {
+	Username string
+	Token    string
+	Room     string
+	Leaving  bool
+}
+
+// HeartbeatReply is what the server answers a heartbeat with. It refuses
+// the heartbeats of a session that ended instead, which is how the client
+// learns it has to log in again.
+type HeartbeatReply struct{}
+
+type PresenceEvent struct {
+	Username string
+	Room     string
+	Kind     PresenceKind
+	At       time.Time
+}
+
+type PresenceEntry struct {
+	Username string
+	Room     string
+	LastSeen time.Time
+}
+
+// Presence is run by the server to track which clients are still connected.
+type Presence struct {
+	entries map[string]PresenceEntry
+	mu      *sync.Mutex
+}
+
+func NewPresence() *Presence {
+	return &Presence{
+		entries: map[string]PresenceEntry{},
+		mu:      &sync.Mutex{},
+	}
+}
+
+// HandleHeartbeat records a heartbeat and returns the event to broadcast, if
+// the heartbeat changes who is connected.
+func (p *Presence) HandleHeartbeat(hb Heartbeat) (PresenceEvent, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	event := PresenceEvent{
+		Username: hb.Username,
+		Room:     hb.Room,
+		At:       now,
+	}
+	if hb.Leaving {
+		delete(p.entries, hb.Username)
+		event.Kind = PresenceLeave
+		return event, true
+	}
+
+	prev, ok := p.entries[hb.Username]
+	p.entries[hb.Username] = PresenceEntry{
+		Username: hb.Username,
+		Room:     hb.Room,
+		LastSeen: now,
+	}
+	// joining a room after connecting is announced again so the room learns about the player
+	if !ok || prev.Room != hb.Room {
+		event.Kind = PresenceJoin
+		return event, true
+	}
+	return PresenceEvent{}, false
+}
+
+// Sweep forgets every client that missed its heartbeats for longer than
+// timeout and returns the timeout events to broadcast.
+func (p *Presence) Sweep(timeout time.Duration) []PresenceEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	events := []PresenceEvent{}
+	for username, entry := range p.entries {
+		if now.Sub(entry.LastSeen) > timeout {
+			delete(p.entries, username)
+			events = append(events, PresenceEvent{
+				Username: username,
+				Room:     entry.Room,
+				Kind:     PresenceTimeout,
+				At:       now,
+			})
+		}
+	}
+	return events
+}
+
+func (p *Presence) IsConnected(username string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.entries[username]
+	return ok
+}
+
+// Room is the room a connected client is playing in, empty while it is in the lobby.
+func (p *Presence) Room(username string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entry, ok := p.entries[username]
+	return entry.Room, ok
+}
+
+// Connected lists every connected client sorted by username.
+func (p *Presence) Connected() []PresenceEntry {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	entries := []PresenceEntry{}
+	for _, entry := range p.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Username < entries[j].Username })
+	return entries
+}
+
+func PrintPlayers(entries []PresenceEntry) {
+	if len(entries) == 0 {
+		fmt.Println("No players connected.")
+		return
+	}
+	for _, entry := range entries {
+		room := entry.Room
+		if room == "" {
+			room = "lobby"
+		}
+		fmt.Printf("* %s in %s, last seen %v ago\n", entry.Username, room, time.Since(entry.LastSeen).Round(time.Second))
+	}
+}
+
+// HandlePresence shows who came and went. Players that left take what is
+// known about their units with them.
+func (gs *GameState) HandlePresence(event PresenceEvent) {
+	if event.Username == gs.GetUsername() {
+		return
+	}
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Presence ====")
+	switch event.Kind {
+	case PresenceJoin:
+		if event.Room == "" {
+			fmt.Printf("%s is online.\n", event.Username)
+		} else {
+			fmt.Printf("%s joined room %s.\n", event.Username, event.Room)
+		}
+	case PresenceLeave:
+		fmt.Printf("%s left the game.\n", event.Username)
+		gs.forgetPlayer(event.Username)
+	case PresenceTimeout:
+		fmt.Printf("%s lost connection, its units stay where they are.\n", event.Username)
+	case PresenceKick:
+		fmt.Printf("%s was removed by the server.\n", event.Username)
+		gs.forgetPlayer(event.Username)
+	}
+}
+
+// Heartbeat is what the client sends the server every HeartbeatInterval.
+func (gs *GameState) Heartbeat() Heartbeat {
+	return Heartbeat{
+		Username: gs.GetUsername(),
+		Token:    gs.getToken(),
+		Room:     gs.GetRoom(),
+	}
+}
+
+// RemovePlayer drops a player that left or was kicked from the world.
+func (w *World) RemovePlayer(username string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.players, username)
+	delete(w.playerPauses, username)
+	delete(w.controlStreaks, username)
+	delete(w.disconnected, username)
+	delete(w.cutOff, username)
+	// a player that left is no opponent, it does not keep a game going
+	delete(w.fielded, username)
+	// a player that comes back starts counting its units from scratch
+	delete(w.dead, username)
+	// wars only wait on the participants still in the room
+	for warID := range w.warsSeen {
+		w.forgetAgreedWar(warID)
+	}
+	w.leaveTeam(username)
+}
+
+// DisconnectPlayer marks a player that timed out. It keeps its units,
+// treasury and territories, so it picks up where it left off once it is
+// back.
+func (w *World) DisconnectPlayer(username string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.players[username]; ok {
+		w.disconnected[username] = struct{}{}
+	}
+}
+
+// Disconnected lists the players that timed out and are not back yet,
+// sorted by username.
+func (w *World) Disconnected() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	usernames := []string{}
+	for username := range w.disconnected {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// Leave removes a disconnected player from the lobby.
+func (l *Lobby) Leave(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.online, username)
+	l.leaveGames(username)
+}
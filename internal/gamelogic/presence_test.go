@@ -0,0 +1,97 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDisconnectedPlayerKeepsItsTreasury(t *testing.T) {
+	w := NewWorld("r1")
+	alice := Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}}
+	w.HandlePlayerState(alice)
+	before := w.treasuries["alice"]
+
+	w.DisconnectPlayer("alice")
+	if got := w.Disconnected(); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("expected alice to be disconnected, got %v", got)
+	}
+	if got := w.Usernames(); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("expected alice to stay in the room, got %v", got)
+	}
+
+	// back with the same units, nothing is charged again
+	tu, _ := w.HandlePlayerState(alice)
+	if tu.Balance != before {
+		t.Errorf("expected the treasury to stay at %v, got %v", before, tu.Balance)
+	}
+	if got := w.Disconnected(); len(got) != 0 {
+		t.Errorf("expected alice to be back, got %v", got)
+	}
+}
+
+func TestDisconnectedPlayerIsNotEliminated(t *testing.T) {
+	w := NewWorld("r1")
+	w.SetVictory(VictoryConfig{Kind: VictoryElimination})
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}})
+
+	w.DisconnectPlayer("bob")
+	if _, over := w.CheckVictory(); over {
+		t.Error("expected the game to go on while bob is disconnected")
+	}
+
+	w.RemovePlayer("bob")
+	if got := w.Disconnected(); len(got) != 0 {
+		t.Errorf("expected a removed player not to be listed as disconnected, got %v", got)
+	}
+}
+
+func TestHeartbeatsAnnounceJoinsAndLeaves(t *testing.T) {
+	p := NewPresence()
+	cases := []struct {
+		hb   Heartbeat
+		want PresenceKind
+		ok   bool
+	}{
+		{Heartbeat{Username: "alice"}, PresenceJoin, true},
+		{Heartbeat{Username: "alice"}, "", false},
+		{Heartbeat{Username: "alice", Room: "r1"}, PresenceJoin, true},
+		{Heartbeat{Username: "alice", Room: "r1", Leaving: true}, PresenceLeave, true},
+	}
+	for i, c := range cases {
+		event, ok := p.HandleHeartbeat(c.hb)
+		if ok != c.ok || event.Kind != c.want {
+			t.Errorf("heartbeat %v: expected %q (%v), got %q (%v)", i, c.want, c.ok, event.Kind, ok)
+		}
+	}
+	if p.IsConnected("alice") {
+		t.Error("expected alice to be gone after leaving")
+	}
+}
+
+func TestSweepTimesOutSilentClients(t *testing.T) {
+	p := NewPresence()
+	p.HandleHeartbeat(Heartbeat{Username: "alice", Room: "r1"})
+	p.HandleHeartbeat(Heartbeat{Username: "bob", Room: "r1"})
+	entry := p.entries["alice"]
+	entry.LastSeen = time.Now().Add(-2 * PresenceTimeoutAfter)
+	p.entries["alice"] = entry
+
+	events := p.Sweep(PresenceTimeoutAfter)
+	if len(events) != 1 || events[0].Username != "alice" || events[0].Kind != PresenceTimeout || events[0].Room != "r1" {
+		t.Fatalf("expected alice to time out of r1, got %v", events)
+	}
+	if p.IsConnected("alice") || !p.IsConnected("bob") {
+		t.Errorf("expected only bob to stay connected, got %v", p.Connected())
+	}
+	if events := p.Sweep(PresenceTimeoutAfter); len(events) != 0 {
+		t.Errorf("expected a timeout to be reported once, got %v", events)
+	}
+}
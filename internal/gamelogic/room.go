@@ -0,0 +1,38 @@
+package gamelogic
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// CommandJoin asks the server about the room the player wants to play in.
+// The player only joins it with JoinRoom once the server answered, so it
+// never plays in a room nobody hosts.
+func (gs *GameState) CommandJoin(words []string) (SyncRequest, error) {
+	if room := gs.GetRoom(); room != "" {
+		return SyncRequest{}, fmt.Errorf("error: you already joined room %s", room)
+	}
+	room := routing.DefaultRoom
+	if len(words) > 1 {
+		room = words[1]
+	}
+	err := routing.ValidateRoom(room)
+	if err != nil {
+		return SyncRequest{}, err
+	}
+	req := gs.NewSyncRequest()
+	req.Room = room
+	return req, nil
+}
+
+// JoinRoom picks the room the player plays in. A client can only join a
+// single room since its subscriptions are scoped to it.
+func (gs *GameState) JoinRoom(room string) error {
+	if joined := gs.GetRoom(); joined != "" {
+		return fmt.Errorf("error: you already joined room %s", joined)
+	}
+	gs.setRoom(room)
+	fmt.Printf("Joined room %s\n", room)
+	return nil
+}
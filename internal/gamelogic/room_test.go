@@ -0,0 +1,35 @@
+package gamelogic
+
+import "testing"
+
+func TestJoinWaitsForTheServer(t *testing.T) {
+	gs := NewGameState("alice")
+	req, err := gs.CommandJoin([]string{"join", "r1"})
+	if err != nil {
+		t.Fatalf("expected r1 to be a valid room, got %v", err)
+	}
+	if req.Room != "r1" || req.Username != "alice" {
+		t.Errorf("expected a sync request of alice for r1, got %+v", req)
+	}
+	if room := gs.GetRoom(); room != "" {
+		t.Fatalf("expected no room before the server answered, got %s", room)
+	}
+
+	if err := gs.JoinRoom("r1"); err != nil {
+		t.Fatalf("expected to join r1, got %v", err)
+	}
+	if err := gs.JoinRoom("r2"); err == nil {
+		t.Error("expected a second room to be refused")
+	}
+	if _, err := gs.CommandJoin([]string{"join", "r2"}); err == nil {
+		t.Error("expected joining again to be refused")
+	}
+}
+
+func TestJoinDefaultsToDefaultRoom(t *testing.T) {
+	gs := NewGameState("alice")
+	req, err := gs.CommandJoin([]string{"join"})
+	if err != nil || req.Room != "default" {
+		t.Errorf("expected the default room, got %+v %v", req, err)
+	}
+}
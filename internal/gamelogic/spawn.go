@@ -22,13 +22,19 @@ func (gs *GameState) CommandSpawn(words []string) error {
 		return fmt.Errorf("error: %s is not a valid unit", rank)
 	}
 
-	id := len(gs.getUnitsSnap()) + 1
+	cost := getAllRankCosts()[UnitRank(rank)]
+	if !gs.spend(cost) {
+		return fmt.Errorf("error: a(n) %s costs %v gold, you only have %v", rank, cost, gs.getTreasury())
+	}
+
+	id := gs.newUnitID()
 	gs.addUnit(Unit{
 		ID:       id,
 		Rank:     UnitRank(rank),
 		Location: Location(locationName),
+		HP:       getAllRankHP()[UnitRank(rank)],
 	})
 
-	fmt.Printf("Spawned a(n) %s in %s with id %v\n", rank, locationName, id)
+	fmt.Printf("Spawned a(n) %s in %s with id %v for %v gold\n", rank, locationName, id, cost)
 	return nil
 }
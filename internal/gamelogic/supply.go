@@ -0,0 +1,136 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// attritionDamage is what a unit stacked beyond its location's supply loses
+// every turn.
+const attritionDamage = 5
+
+// suppliedLocations traces supply from home through the adjacent locations
+// the player has units in or that are friendly-held, by the player or its
+// teammates. Home always supplies, held or not.
+func suppliedLocations(p Player, home Location, friendly []Location) map[Location]bool {
+	held := map[Location]bool{}
+	for _, loc := range holdings(p) {
+		held[loc] = true
+	}
+	for _, loc := range friendly {
+		held[loc] = true
+	}
+	supplied := map[Location]bool{home: true}
+	queue := []Location{home}
+	adjacent := getAdjacentLocations()
+	for len(queue) > 0 {
+		loc := queue[0]
+		queue = queue[1:]
+		for _, next := range adjacent[loc] {
+			if held[next] && !supplied[next] {
+				supplied[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return supplied
+}
+
+// supplyFor works out which of the player's locations are cut off from home
+// and the attrition of its units. A player without a home base yet is
+// supplied everywhere. Units beyond a location's supply suffer attrition
+// newest first, so a player's veterans outlast its fresh recruits.
+func supplyFor(p Player, home Location, friendly []Location) SupplyUpdate {
+	su := SupplyUpdate{
+		Username:    p.Username,
+		Home:        home,
+		OutOfSupply: []Location{},
+		Attrition:   map[int]int{},
+	}
+	if home != "" {
+		supplied := suppliedLocations(p, home, friendly)
+		for _, loc := range holdings(p) {
+			if !supplied[loc] {
+				su.OutOfSupply = append(su.OutOfSupply, loc)
+			}
+		}
+		sort.Slice(su.OutOfSupply, func(i, j int) bool { return su.OutOfSupply[i] < su.OutOfSupply[j] })
+	}
+
+	capacities := getAllLocationSupply()
+	for _, loc := range holdings(p) {
+		units := unitsInLocation(p, loc)
+		for _, unit := range units[min(capacities[loc], len(units)):] {
+			su.Attrition[unit.ID] = min(attritionDamage, unit.health())
+		}
+	}
+	return su
+}
+
+// HandleSupplyUpdate mirrors the locations the server found cut off from
+// home and applies attrition. It reports whether any unit changed, so the
+// server hears about it.
+func (gs *GameState) HandleSupplyUpdate(su SupplyUpdate) bool {
+	gs.setHome(su.Home)
+	cutOffChanged := !slices.Equal(gs.getOutOfSupply(), su.OutOfSupply)
+	gs.setOutOfSupply(su.OutOfSupply)
+	changed := false
+	lost := []int{}
+	for _, unit := range gs.GetPlayerSnap().Units {
+		damage, hurt := su.Attrition[unit.ID]
+		if !hurt {
+			continue
+		}
+		changed = true
+		unit.HP = unit.health() - damage
+		if unit.HP <= 0 {
+			lost = append(lost, unit.ID)
+			continue
+		}
+		gs.UpdateUnit(unit)
+	}
+	if !changed && !cutOffChanged {
+		return false
+	}
+
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Supply ====")
+	for _, loc := range su.OutOfSupply {
+		fmt.Printf("Your units in %s are cut off from %s and fight at reduced power!\n", loc, su.Home)
+	}
+	if len(su.Attrition) > 0 {
+		fmt.Printf("%v of your units suffer attrition from being stacked beyond their location's supply.\n", len(su.Attrition))
+	}
+	if len(lost) > 0 {
+		gs.removeUnits(lost)
+		sort.Ints(lost)
+		fmt.Printf("%v of your units were lost to attrition: %v\n", len(lost), lost)
+	}
+	return changed
+}
+
+func (gs *GameState) getHome() Location {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return gs.home
+}
+
+func (gs *GameState) setHome(home Location) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.home = home
+}
+
+func (gs *GameState) getOutOfSupply() []Location {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	return append([]Location{}, gs.outOfSupply...)
+}
+
+func (gs *GameState) setOutOfSupply(locations []Location) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.outOfSupply = locations
+}
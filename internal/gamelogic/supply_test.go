@@ -0,0 +1,158 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSupplyTracesThroughTeammates(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "australia"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}})
+
+	su := w.Resupply()[0]
+	if !reflect.DeepEqual(su.OutOfSupply, []Location{"australia"}) {
+		t.Fatalf("expected australia to be cut off without bob, got %v", su.OutOfSupply)
+	}
+
+	w.HandleTeamRequest(TeamRequest{Username: "alice", Action: TeamActionJoin, Team: "red"})
+	w.HandleTeamRequest(TeamRequest{Username: "alice", Action: TeamActionInvite, Player: "bob"})
+	w.HandleTeamRequest(TeamRequest{Username: "bob", Action: TeamActionJoin, Team: "red"})
+	su = w.Resupply()[0]
+	if len(su.OutOfSupply) != 0 {
+		t.Errorf("expected bob's asia to carry alice's supply, got %v cut off", su.OutOfSupply)
+	}
+}
+
+func TestBattlefieldReportsCutOffPlayers(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "australia"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "australia"},
+	}})
+	w.Resupply()
+
+	bf, err := w.Battlefield("bob", "australia")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(bf.CutOff, []string{"alice"}) {
+		t.Errorf("expected only alice to be cut off in australia, got %v", bf.CutOff)
+	}
+}
+
+func TestCutOffResolutionSurvivesTheWire(t *testing.T) {
+	defender := NewGameState("defender")
+	if err := defender.CommandSpawn([]string{"spawn", "europe", "artillery"}); err != nil {
+		t.Fatal(err)
+	}
+	attacker := Player{
+		Username: "attacker",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankInfantry, Location: "europe"}},
+	}
+	rw, ok := defender.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{
+		Location: "europe",
+		Players:  []Player{attacker},
+		CutOff:   []string{"defender"},
+	})
+	if !ok {
+		t.Fatal("expected the only defender to declare the war")
+	}
+	res, _ := resolveWarWithDecisions(rw, nil)
+	if len(res.Survivors["defender"]) == 0 {
+		t.Fatal("expected the cut off defender to survive")
+	}
+	defender.HandleWar(rw)
+
+	data, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received WarResolution
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if _, ack, _ := defender.HandleWarResolution(received); !ack.Agreed {
+		t.Error("expected the resolution to still match after the round trip")
+	}
+}
+
+func TestResolverAppliesServerSupply(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{Username: "attacker", Units: map[int]Unit{
+			1: {ID: 1, Rank: RankCavalry, Location: "europe"},
+			2: {ID: 2, Rank: RankCavalry, Location: "europe"},
+		}},
+		Defender: Player{Username: "defender", Units: map[int]Unit{
+			1: {ID: 1, Rank: RankCavalry, Location: "europe"},
+			2: {ID: 2, Rank: RankInfantry, Location: "europe"},
+		}},
+		Location: "europe",
+		Model:    CombatModelPower,
+	}
+	res, _ := resolveWar(rw)
+	if res.Winner != "attacker" {
+		t.Fatalf("expected the supplied attacker to win, got %q", res.Winner)
+	}
+
+	rw.CutOff = []string{"attacker"}
+	res, _ = resolveWar(rw)
+	if res.Winner != "defender" {
+		t.Errorf("expected the cut off attacker to lose, got %q", res.Winner)
+	}
+}
+
+func TestPowerAppliesSupply(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	opponents := []Unit{{ID: 1, Rank: RankCavalry}}
+
+	cavalry := []Unit{{ID: 1, Rank: RankCavalry}}
+	cutOff := map[int]bool{1: true}
+	if got := calc.power(cavalry, cutOff, opponents, "europe", false); got != 2.5 {
+		t.Errorf("expected out of supply cavalry power 2.5, got %v", got)
+	}
+	neutral := NewCombatCalculator(neutralCombatConfig())
+	if got := neutral.power(cavalry, cutOff, opponents, "europe", false); got != 5 {
+		t.Errorf("expected rules without a supply penalty to ignore it, got %v", got)
+	}
+}
+
+func TestSupplyTracesThroughHeldLocations(t *testing.T) {
+	p := Player{
+		Username: "player",
+		Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+			2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+			3: {ID: 3, Rank: RankInfantry, Location: "australia"},
+			// antarctica is next to australia but only reachable from home through it
+			4: {ID: 4, Rank: RankInfantry, Location: "antarctica"},
+			5: {ID: 5, Rank: RankInfantry, Location: "antarctica"},
+			6: {ID: 6, Rank: RankInfantry, Location: "antarctica", HP: 3},
+		},
+	}
+
+	su := supplyFor(p, "europe", nil)
+	if len(su.OutOfSupply) != 0 {
+		t.Errorf("expected every location to be supplied, got %v cut off", su.OutOfSupply)
+	}
+	want := map[int]int{6: 3}
+	if !reflect.DeepEqual(su.Attrition, want) {
+		t.Errorf("expected attrition %v, got %v", want, su.Attrition)
+	}
+
+	delete(p.Units, 2)
+	su = supplyFor(p, "europe", nil)
+	if !reflect.DeepEqual(su.OutOfSupply, []Location{"antarctica", "australia"}) {
+		t.Errorf("expected antarctica and australia to be cut off without asia, got %v", su.OutOfSupply)
+	}
+}
@@ -0,0 +1,126 @@
+package gamelogic
+
+import (
+	"fmt"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// SyncRequest is sent by a client right after it joins a room. Its
+// subscriptions only see what is published from then on, so the server
+// answers with everything that happened before.
+type SyncRequest struct {
+	Username string
+	Token    string
+	Room     string
+}
+
+// WorldSnapshot is the part of the server's world a player is allowed to
+// see. Self is only set for a player the room already knows, e.g. one that
+// reconnected after a crash.
+type WorldSnapshot struct {
+	Room     string
+	Pause    routing.PlayingState
+	Players  []string
+	Victory  VictoryConfig
+	Over     bool
+	Self     Player
+	Treasury int
+	Known    bool
+	Control  []Territory
+	Team     string
+	// the first unit ID the player may use, the IDs of units the server
+	// removed are never handed out again
+	NextUnitID int
+}
+
+func (gs *GameState) NewSyncRequest() SyncRequest {
+	return SyncRequest{
+		Username: gs.GetUsername(),
+		Token:    gs.getToken(),
+		Room:     gs.GetRoom(),
+	}
+}
+
+// Snapshot is what username may know about the room when it joins it.
+func (w *World) Snapshot(username string) WorldSnapshot {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	snap := WorldSnapshot{
+		Room:    w.room,
+		Players: w.usernames(),
+		Victory: w.victory,
+		Over:    w.over,
+		Control: w.territories(),
+		Team:    w.teams[username],
+	}
+	// a room wide pause outranks a player's own
+	snap.Pause = w.pause
+	if !snap.Pause.IsPaused || snap.Pause.Expired() {
+		snap.Pause = w.playerPauses[username]
+	}
+	if snap.Pause.Expired() {
+		snap.Pause = routing.PlayingState{}
+	}
+	if p, ok := w.players[username]; ok {
+		snap.Self = p
+		snap.Treasury = w.treasuries[username]
+		snap.NextUnitID = w.nextUnitID(username)
+		snap.Known = true
+	}
+	return snap
+}
+
+// HandleSync catches up with a room the player just joined.
+func (gs *GameState) HandleSync(snap WorldSnapshot) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Printf("==== Room %s ====\n", snap.Room)
+	if snap.Known {
+		gs.restore(snap.Self, snap.Treasury, snap.NextUnitID)
+		fmt.Printf("Welcome back! You have %v unit(s) and %v gold.\n", len(snap.Self.Units), snap.Treasury)
+	}
+	gs.setTerritories(snap.Control)
+	gs.setTeam(snap.Team)
+	if snap.Team != "" {
+		fmt.Printf("You are on team %s.\n", snap.Team)
+	}
+	others := []string{}
+	for _, username := range snap.Players {
+		if username != gs.GetUsername() {
+			others = append(others, username)
+		}
+	}
+	if len(others) == 0 {
+		fmt.Println("Nobody else is playing in this room yet.")
+	} else {
+		fmt.Printf("Already playing: %v\n", others)
+	}
+	fmt.Printf("Victory condition: %v\n", snap.Victory)
+	if snap.Over {
+		fmt.Println("The game in this room is already over.")
+		gs.pauseGame()
+		return
+	}
+	if snap.Pause.IsPaused {
+		fmt.Println("The game is paused.")
+		gs.setPause(snap.Pause)
+		printPause(snap.Pause)
+	}
+}
+
+// restore takes back the units and balance the server remembers for the
+// player.
+func (gs *GameState) restore(p Player, treasury, nextUnitID int) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.Player.Units = map[int]Unit{}
+	for id, unit := range p.Units {
+		gs.Player.Units[id] = unit
+		if id >= gs.nextUnitID {
+			gs.nextUnitID = id + 1
+		}
+	}
+	gs.nextUnitID = max(gs.nextUnitID, nextUnitID)
+	gs.treasury = treasury
+}
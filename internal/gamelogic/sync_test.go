@@ -0,0 +1,92 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestSnapshotOnlyRestoresKnownPlayers(t *testing.T) {
+	w := NewWorld("r1")
+	alice := Player{Username: "alice", Units: map[int]Unit{
+		3: {ID: 3, Rank: RankInfantry, Location: "europe"},
+	}}
+	w.HandlePlayerState(alice)
+
+	snap := w.Snapshot("alice")
+	if !snap.Known || !reflect.DeepEqual(snap.Self, alice) {
+		t.Errorf("expected alice to get the units back, got %+v", snap)
+	}
+	if snap.NextUnitID != 4 {
+		t.Errorf("expected unit IDs to go on from 4, got %v", snap.NextUnitID)
+	}
+	if snap.Treasury != w.treasuries["alice"] {
+		t.Errorf("expected treasury %v, got %v", w.treasuries["alice"], snap.Treasury)
+	}
+	if snap.Room != "r1" || !reflect.DeepEqual(snap.Players, []string{"alice"}) {
+		t.Errorf("expected room r1 with alice, got %v with %v", snap.Room, snap.Players)
+	}
+
+	if snap := w.Snapshot("bob"); snap.Known || len(snap.Self.Units) != 0 {
+		t.Errorf("expected nothing to restore for bob, got %+v", snap)
+	}
+}
+
+func TestSnapshotPause(t *testing.T) {
+	w := NewWorld("r1")
+	w.SetPlayerPaused("bob", routing.PlayingState{IsPaused: true, Reason: "bob only"})
+	if got := w.Snapshot("bob").Pause.Reason; got != "bob only" {
+		t.Errorf("expected bob's own pause, got %q", got)
+	}
+	if got := w.Snapshot("alice").Pause; got.IsPaused {
+		t.Errorf("expected alice not to be paused, got %+v", got)
+	}
+
+	w.SetPlayerPaused("bob", routing.PlayingState{IsPaused: true, Expiry: time.Now().Add(-time.Second)})
+	if got := w.Snapshot("bob").Pause; got.IsPaused {
+		t.Errorf("expected an expired pause to count as resumed, got %+v", got)
+	}
+
+	w.SetPaused(routing.PlayingState{IsPaused: true, Reason: "everyone"})
+	if got := w.Snapshot("bob").Pause.Reason; got != "everyone" {
+		t.Errorf("expected the room wide pause, got %q", got)
+	}
+}
+
+func TestHandleSyncRestoresThePlayer(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.HandleSync(WorldSnapshot{
+		Room:    "r1",
+		Players: []string{"alice", "bob"},
+		Self: Player{Username: "alice", Units: map[int]Unit{
+			3: {ID: 3, Rank: RankInfantry, Location: "europe"},
+		}},
+		Treasury: 42,
+		Known:    true,
+		Pause:    routing.PlayingState{IsPaused: true},
+		Team:     "blue",
+	})
+
+	if got := gs.getTreasury(); got != 42 {
+		t.Errorf("expected treasury 42, got %v", got)
+	}
+	if got := gs.getUnitsSnap(); len(got) != 1 || got[0].ID != 3 {
+		t.Errorf("expected unit 3 back, got %v", got)
+	}
+	if !gs.isPaused() {
+		t.Error("expected the game to be paused")
+	}
+	if got := gs.GetTeam(); got != "blue" {
+		t.Errorf("expected team blue, got %q", got)
+	}
+
+	// new units must not reuse the restored IDs
+	if err := gs.CommandSpawn([]string{"spawn", "asia", "infantry"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := gs.GetPlayerSnap().Units[4]; !ok {
+		t.Errorf("expected the new unit to get ID 4, got %v", gs.GetPlayerSnap().Units)
+	}
+}
@@ -0,0 +1,184 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+type TeamAction string
+
+const (
+	TeamActionJoin   TeamAction = "join"
+	TeamActionInvite TeamAction = "invite"
+	TeamActionLeave  TeamAction = "leave"
+)
+
+// TeamRequest asks the server to change a player's team. Teams are kept by
+// the server: whoever joins a team first founds it, everyone after needs an
+// invitation from a member. Team is the team to join, Player the player to
+// invite.
+type TeamRequest struct {
+	Username string
+	Token    string
+	Room     string
+	Action   TeamAction
+	Team     string
+	Player   string
+}
+
+// TeamResponse is the server's answer, Team is the player's team afterwards.
+type TeamResponse struct {
+	Team    string
+	Message string
+}
+
+// IsTeamAction reports whether a word after "team" changes the team rather
+// than starting a message.
+func IsTeamAction(word string) bool {
+	switch TeamAction(word) {
+	case TeamActionJoin, TeamActionInvite, TeamActionLeave:
+		return true
+	default:
+		return false
+	}
+}
+
+// CommandTeamRequest asks to join a team, invite someone to it or leave it.
+func (gs *GameState) CommandTeamRequest(words []string) (TeamRequest, error) {
+	req := TeamRequest{
+		Username: gs.GetUsername(),
+		Token:    gs.getToken(),
+		Room:     gs.GetRoom(),
+		Action:   TeamAction(words[1]),
+	}
+	switch req.Action {
+	case TeamActionJoin:
+		if len(words) < 3 {
+			return TeamRequest{}, errors.New("usage: team join <name>")
+		}
+		if team := gs.GetTeam(); team != "" {
+			return TeamRequest{}, fmt.Errorf("error: you already are on team %s", team)
+		}
+		err := routing.ValidateName(words[2])
+		if err != nil {
+			return TeamRequest{}, err
+		}
+		req.Team = words[2]
+	case TeamActionInvite:
+		if len(words) < 3 {
+			return TeamRequest{}, errors.New("usage: team invite <player>")
+		}
+		if gs.GetTeam() == "" {
+			return TeamRequest{}, errors.New("error: join a team first: team join <name>")
+		}
+		req.Player = words[2]
+	case TeamActionLeave:
+		if gs.GetTeam() == "" {
+			return TeamRequest{}, errors.New("error: you are not on a team")
+		}
+	default:
+		return TeamRequest{}, fmt.Errorf("error: unknown team action %s", words[1])
+	}
+	return req, nil
+}
+
+// HandleTeamResponse mirrors the team the server put the player on.
+func (gs *GameState) HandleTeamResponse(resp TeamResponse) {
+	gs.setTeam(resp.Team)
+	fmt.Println(resp.Message)
+}
+
+// HandleTeamRequest is run by the server to change a player's team.
+func (w *World) HandleTeamRequest(req TeamRequest) (TeamResponse, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	team := w.teams[req.Username]
+	switch req.Action {
+	case TeamActionJoin:
+		if team != "" {
+			return TeamResponse{}, fmt.Errorf("you already are on team %s", team)
+		}
+		err := routing.ValidateName(req.Team)
+		if err != nil {
+			return TeamResponse{}, err
+		}
+		_, invited := w.invites[req.Team][req.Username]
+		if len(w.members(req.Team)) > 0 && !invited {
+			return TeamResponse{}, fmt.Errorf("team %s only takes players its members invite", req.Team)
+		}
+		delete(w.invites[req.Team], req.Username)
+		w.teams[req.Username] = req.Team
+		return TeamResponse{
+			Team:    req.Team,
+			Message: fmt.Sprintf("Joined team %s", req.Team),
+		}, nil
+	case TeamActionInvite:
+		if team == "" {
+			return TeamResponse{}, errors.New("you are not on a team")
+		}
+		if _, ok := w.invites[team]; !ok {
+			w.invites[team] = map[string]struct{}{}
+		}
+		w.invites[team][req.Player] = struct{}{}
+		return TeamResponse{
+			Team:    team,
+			Message: fmt.Sprintf("Invited %s to team %s", req.Player, team),
+		}, nil
+	case TeamActionLeave:
+		if team == "" {
+			return TeamResponse{}, errors.New("you are not on a team")
+		}
+		w.leaveTeam(req.Username)
+		return TeamResponse{
+			Message: fmt.Sprintf("Left team %s", team),
+		}, nil
+	default:
+		return TeamResponse{}, fmt.Errorf("unknown team action %s", req.Action)
+	}
+}
+
+// Team is the team the server put username on, and its other members sorted
+// by username.
+func (w *World) Team(username string) (string, []string) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	team := w.teams[username]
+	if team == "" {
+		return "", nil
+	}
+	teammates := []string{}
+	for _, member := range w.members(team) {
+		if member != username {
+			teammates = append(teammates, member)
+		}
+	}
+	return team, teammates
+}
+
+// members must be called with the lock held.
+func (w *World) members(team string) []string {
+	members := []string{}
+	for username, t := range w.teams {
+		if t == team {
+			members = append(members, username)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// leaveTeam must be called with the lock held. A team that loses its last
+// member is gone, invitations included.
+func (w *World) leaveTeam(username string) {
+	team, ok := w.teams[username]
+	if !ok {
+		return
+	}
+	delete(w.teams, username)
+	if len(w.members(team)) == 0 {
+		delete(w.invites, team)
+	}
+}
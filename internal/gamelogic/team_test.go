@@ -0,0 +1,96 @@
+package gamelogic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+func TestTeamNeedsAnInvitation(t *testing.T) {
+	w := NewWorld("r1")
+	join := func(username string) error {
+		_, err := w.HandleTeamRequest(TeamRequest{Username: username, Action: TeamActionJoin, Team: "red"})
+		return err
+	}
+
+	if err := join("alice"); err != nil {
+		t.Fatalf("expected alice to found team red, got %v", err)
+	}
+	if err := join("bob"); err == nil {
+		t.Fatal("expected bob to need an invitation")
+	}
+
+	_, err := w.HandleTeamRequest(TeamRequest{Username: "alice", Action: TeamActionInvite, Player: "bob"})
+	if err != nil {
+		t.Fatalf("expected alice to invite bob, got %v", err)
+	}
+	if err := join("bob"); err != nil {
+		t.Fatalf("expected invited bob to join, got %v", err)
+	}
+	team, teammates := w.Team("alice")
+	if team != "red" || !reflect.DeepEqual(teammates, []string{"bob"}) {
+		t.Errorf("expected alice on red with bob, got %s %v", team, teammates)
+	}
+
+	// the invitation is used up
+	_, err = w.HandleTeamRequest(TeamRequest{Username: "bob", Action: TeamActionLeave})
+	if err != nil {
+		t.Fatalf("expected bob to leave, got %v", err)
+	}
+	if err := join("bob"); err == nil {
+		t.Error("expected bob to need a new invitation")
+	}
+}
+
+func TestOnlyMembersInvite(t *testing.T) {
+	w := NewWorld("r1")
+	_, err := w.HandleTeamRequest(TeamRequest{Username: "mallory", Action: TeamActionInvite, Player: "mallory"})
+	if err == nil {
+		t.Error("expected a player without a team to be refused")
+	}
+}
+
+func TestLastMemberLeavingDisbandsTeam(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandleTeamRequest(TeamRequest{Username: "alice", Action: TeamActionJoin, Team: "red"})
+	w.HandleTeamRequest(TeamRequest{Username: "alice", Action: TeamActionInvite, Player: "bob"})
+	w.RemovePlayer("alice")
+
+	// red is free to be founded again, its old invitations are gone
+	_, err := w.HandleTeamRequest(TeamRequest{Username: "carol", Action: TeamActionJoin, Team: "red"})
+	if err != nil {
+		t.Fatalf("expected carol to found red again, got %v", err)
+	}
+	_, err = w.HandleTeamRequest(TeamRequest{Username: "bob", Action: TeamActionJoin, Team: "red"})
+	if err == nil {
+		t.Error("expected bob's old invitation to be gone")
+	}
+}
+
+func TestChatRoute(t *testing.T) {
+	tests := []struct {
+		channel  ChatChannel
+		exchange string
+		key      string
+	}{
+		{ChatGlobal, routing.ExchangePerilTopic, "r1.chat.global"},
+		{ChatDirect, routing.ExchangeDefault, "r1.chat"},
+		{ChatTeam, routing.ExchangeDefault, "r1.chat"},
+	}
+	for _, tt := range tests {
+		msg := ChatMessage{Room: "r1", Channel: tt.channel, To: "bob", Team: "red"}
+		exchange, key := msg.Route()
+		if exchange != tt.exchange || key != tt.key {
+			t.Errorf("%s: expected %q %q, got %q %q", tt.channel, tt.exchange, tt.key, exchange, key)
+		}
+	}
+}
+
+func TestChatLogNamesChannel(t *testing.T) {
+	msg := ChatMessage{Username: "alice", Room: "r1", Channel: ChatTeam, Team: "red", Message: "attack"}
+	if got := ChatLog(msg).Message; !strings.Contains(got, "team red") {
+		t.Errorf("expected the log to name team red, got %q", got)
+	}
+}
@@ -0,0 +1,319 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Territory is who controls a location. A player takes control of a
+// location by being the only one with units there and keeps it until
+// someone else does. Contested is set while several players have units in
+// it, the owner only holds on to it as long as it is one of them.
+type Territory struct {
+	Location  Location
+	Owner     string
+	Contested bool
+}
+
+// ControlChange is broadcast by the server whenever a territory changes
+// hands or becomes or stops being contested.
+type ControlChange struct {
+	Territory Territory
+	Previous  string
+	ChangedAt time.Time
+}
+
+// UpdateControl works out who controls each location from where the
+// players' units are, and returns what changed since the last update.
+func (w *World) UpdateControl() []ControlChange {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	changes := []ControlChange{}
+	for _, loc := range sortedLocations() {
+		present := []string{}
+		for _, username := range w.usernames() {
+			if len(unitsInLocation(w.players[username], loc)) > 0 {
+				present = append(present, username)
+			}
+		}
+
+		prev, ok := w.control[loc]
+		if !ok {
+			prev = Territory{Location: loc}
+		}
+		next := Territory{Location: loc, Owner: prev.Owner}
+		if _, ok := w.players[next.Owner]; !ok {
+			// a player that left the room holds nothing
+			next.Owner = ""
+		}
+		switch {
+		case len(present) == 1:
+			next.Owner = present[0]
+		case len(present) > 1:
+			next.Contested = true
+			if !slices.Contains(present, next.Owner) {
+				next.Owner = ""
+			}
+		}
+		if next == prev {
+			continue
+		}
+		w.control[loc] = next
+		changes = append(changes, ControlChange{
+			Territory: next,
+			Previous:  prev.Owner,
+			ChangedAt: time.Now(),
+		})
+	}
+	return changes
+}
+
+// WarAgreementTimeout is how long the server waits for every participant
+// of a war it overheard to agree to its resolution. Defenders may hold a
+// resolution for ResolutionHoldTime before they answer it.
+const WarAgreementTimeout = 2 * ResolutionHoldTime
+
+// HandleWarResolution records a resolution the server overheard. Nobody's
+// casualties are trusted to the attacker alone, each participant's are only
+// taken off the server's records once it agreed to them. It reports whether
+// any units were removed.
+func (w *World) HandleWarResolution(res WarResolution) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.resolutions[res.WarID]; ok {
+		// every participant's queue gets a copy
+		return false
+	}
+	w.seeWar(res.WarID)
+	w.resolutions[res.WarID] = res
+	return w.applyAgreedCasualties(res.WarID)
+}
+
+// HandleWarAck records a participant agreeing to, or disputing, a
+// resolution. Acks may overtake their resolution, they are kept until it
+// arrives. It reports whether any units were removed.
+func (w *World) HandleWarAck(ack WarAck) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !ack.Agreed {
+		return false
+	}
+	w.seeWar(ack.WarID)
+	if _, ok := w.agreed[ack.WarID]; !ok {
+		w.agreed[ack.WarID] = map[string]struct{}{}
+	}
+	w.agreed[ack.WarID][ack.Username] = struct{}{}
+	return w.applyAgreedCasualties(ack.WarID)
+}
+
+// applyAgreedCasualties must be called with the lock held. Survivors take
+// the wounds and experience the resolution gave them. A war is forgotten
+// once every participant agreed to it.
+func (w *World) applyAgreedCasualties(warID string) bool {
+	res, ok := w.resolutions[warID]
+	if !ok {
+		return false
+	}
+	removed := false
+	for username := range w.agreed[warID] {
+		p, ok := w.players[username]
+		if !ok {
+			continue
+		}
+		survivors := map[int]Unit{}
+		for _, unit := range res.Survivors[username] {
+			survivors[unit.ID] = unit
+		}
+		units := map[int]Unit{}
+		for id, unit := range p.Units {
+			if slices.Contains(res.Casualties[username], id) {
+				w.bury(username, id)
+				removed = true
+				continue
+			}
+			if survivor, ok := survivors[id]; ok {
+				unit.HP = survivor.HP
+				unit.XP = survivor.XP
+			}
+			units[id] = unit
+		}
+		p.Units = units
+		w.players[username] = p
+	}
+	w.forgetAgreedWar(warID)
+	return removed
+}
+
+// seeWar must be called with the lock held.
+func (w *World) seeWar(warID string) {
+	if _, ok := w.warsSeen[warID]; !ok {
+		w.warsSeen[warID] = time.Now()
+	}
+}
+
+// forgetAgreedWar must be called with the lock held. It forgets a war once
+// every participant still in the room agreed to it.
+func (w *World) forgetAgreedWar(warID string) {
+	res, ok := w.resolutions[warID]
+	if !ok {
+		return
+	}
+	for _, username := range res.Participants() {
+		if _, ok := w.players[username]; !ok {
+			continue
+		}
+		if _, ok := w.agreed[warID][username]; !ok {
+			return
+		}
+	}
+	w.forgetWar(warID)
+}
+
+// forgetWar must be called with the lock held.
+func (w *World) forgetWar(warID string) {
+	delete(w.resolutions, warID)
+	delete(w.agreed, warID)
+	delete(w.warsSeen, warID)
+}
+
+// ExpireWars forgets the wars the server overheard that not every
+// participant agreed to in time, and acks whose resolution never came. The
+// casualties already agreed to stay applied.
+func (w *World) ExpireWars() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for warID, seen := range w.warsSeen {
+		if time.Since(seen) > WarAgreementTimeout {
+			w.forgetWar(warID)
+		}
+	}
+}
+
+// Control is every location's territory, sorted by location.
+func (w *World) Control() []Territory {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.territories()
+}
+
+// territories must be called with the lock held.
+func (w *World) territories() []Territory {
+	territories := []Territory{}
+	for _, loc := range sortedLocations() {
+		t, ok := w.control[loc]
+		if !ok {
+			t = Territory{Location: loc}
+		}
+		territories = append(territories, t)
+	}
+	return territories
+}
+
+// controlledBy must be called with the lock held.
+func (w *World) controlledBy(username string) []Location {
+	locations := []Location{}
+	for _, t := range w.territories() {
+		if t.Owner == username {
+			locations = append(locations, t.Location)
+		}
+	}
+	return locations
+}
+
+// HandleControlChange mirrors a territory changing hands.
+func (gs *GameState) HandleControlChange(cc ControlChange) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Territory ====")
+	gs.setTerritory(cc.Territory)
+	t := cc.Territory
+	username := gs.GetUsername()
+	switch {
+	case t.Contested && t.Owner != "":
+		fmt.Printf("%s is contested, %s still holds it.\n", t.Location, ownerName(t.Owner, username))
+	case t.Contested:
+		fmt.Printf("%s is contested, nobody holds it.\n", t.Location)
+	case t.Owner == "" && cc.Previous == "":
+		fmt.Printf("%s is no longer contested.\n", t.Location)
+	case t.Owner == "":
+		fmt.Printf("%s is no longer held by %s.\n", t.Location, ownerName(cc.Previous, username))
+	case t.Owner == cc.Previous:
+		fmt.Printf("%s is no longer contested, %s held it.\n", t.Location, ownerName(t.Owner, username))
+	case cc.Previous == "":
+		fmt.Printf("%s took control of %s.\n", ownerName(t.Owner, username), t.Location)
+	default:
+		fmt.Printf("%s took %s from %s.\n", ownerName(t.Owner, username), t.Location, ownerName(cc.Previous, username))
+	}
+}
+
+// printTerritories lists who controls each location and how many units the
+// player has there.
+func (gs *GameState) printTerritories() {
+	p := gs.GetPlayerSnap()
+	username := gs.GetUsername()
+	for _, t := range gs.getTerritoriesSnap() {
+		fmt.Printf("* %s: ", t.Location)
+		switch {
+		case t.Owner == "" && !t.Contested:
+			fmt.Print("uncontrolled")
+		case t.Owner == "":
+			fmt.Print("contested")
+		case t.Contested:
+			fmt.Printf("contested, held by %s", ownerName(t.Owner, username))
+		default:
+			fmt.Printf("held by %s", ownerName(t.Owner, username))
+		}
+		if units := len(unitsInLocation(p, t.Location)); units > 0 {
+			fmt.Printf(", %v of your unit(s)", units)
+		}
+		fmt.Println()
+	}
+}
+
+func ownerName(owner, username string) string {
+	if owner == username {
+		return "you"
+	}
+	return owner
+}
+
+func sortedLocations() []Location {
+	locations := []Location{}
+	for loc := range getAllLocations() {
+		locations = append(locations, loc)
+	}
+	sort.Slice(locations, func(i, j int) bool { return locations[i] < locations[j] })
+	return locations
+}
+
+func (gs *GameState) setTerritory(t Territory) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.territories[t.Location] = t
+}
+
+// getTerritoriesSnap returns every location's territory sorted by location.
+func (gs *GameState) getTerritoriesSnap() []Territory {
+	gs.mu.RLock()
+	defer gs.mu.RUnlock()
+	territories := []Territory{}
+	for _, loc := range sortedLocations() {
+		t, ok := gs.territories[loc]
+		if !ok {
+			t = Territory{Location: loc}
+		}
+		territories = append(territories, t)
+	}
+	return territories
+}
+
+func (gs *GameState) setTerritories(territories []Territory) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+	gs.territories = map[Location]Territory{}
+	for _, t := range territories {
+		gs.territories[t.Location] = t
+	}
+}
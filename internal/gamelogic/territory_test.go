@@ -0,0 +1,192 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestUpdateControl(t *testing.T) {
+	w := NewWorld("r1")
+	elsewhere := Location("australia")
+	tests := []struct {
+		name     string
+		username string
+		loc      Location
+		want     Territory
+		changed  bool
+	}{
+		{"take", "alice", "europe", Territory{Location: "europe", Owner: "alice"}, true},
+		{"contest", "bob", "europe", Territory{Location: "europe", Owner: "alice", Contested: true}, true},
+		{"owner leaves contested", "alice", elsewhere, Territory{Location: "europe", Owner: "bob"}, true},
+		{"owner leaves", "bob", elsewhere, Territory{Location: "europe", Owner: "bob"}, false},
+		{"retake", "alice", "europe", Territory{Location: "europe", Owner: "alice"}, true},
+	}
+	for _, tt := range tests {
+		// the first report spawns the unit, after that it only moves through moves the server relays
+		w.HandlePlayerState(Player{Username: tt.username, Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: tt.loc},
+		}})
+		w.HandleMove(ArmyMove{Player: Player{Username: tt.username}, ToLocation: tt.loc, Units: []Unit{{ID: 1}}})
+		changed := false
+		for _, change := range w.UpdateControl() {
+			if change.Territory.Location == "europe" {
+				changed = true
+			}
+		}
+		if changed != tt.changed {
+			t.Errorf("%s: expected europe changed %v, got %v", tt.name, tt.changed, changed)
+		}
+		if got := w.control["europe"]; got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestControlledBy(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "australia"},
+		// contested, nobody holds it
+		2: {ID: 2, Rank: RankInfantry, Location: "asia"},
+	}})
+	w.UpdateControl()
+
+	tests := []struct {
+		username string
+		want     []Location
+	}{
+		{"alice", []Location{"europe"}},
+		{"bob", []Location{"australia"}},
+		{"carol", []Location{}},
+	}
+	for _, tt := range tests {
+		if got := w.controlledBy(tt.username); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.username, tt.want, got)
+		}
+	}
+}
+
+func TestHandleControlChange(t *testing.T) {
+	tests := []struct {
+		name string
+		cc   ControlChange
+	}{
+		{"take", ControlChange{Territory: Territory{Location: "europe", Owner: "alice"}}},
+		{"contest", ControlChange{Territory: Territory{Location: "europe", Owner: "alice", Contested: true}, Previous: "alice"}},
+		{"lose", ControlChange{Territory: Territory{Location: "europe", Owner: "bob"}, Previous: "alice"}},
+		{"leave", ControlChange{Territory: Territory{Location: "europe"}, Previous: "bob"}},
+	}
+	gs := NewGameState("alice")
+	for _, tt := range tests {
+		gs.HandleControlChange(tt.cc)
+		for _, territory := range gs.getTerritoriesSnap() {
+			if territory.Location == "europe" && territory != tt.cc.Territory {
+				t.Errorf("%s: expected %+v, got %+v", tt.name, tt.cc.Territory, territory)
+			}
+		}
+	}
+}
+
+func TestAgreedWarCasualtiesChangeControl(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankArtillery, Location: "europe"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	w.UpdateControl()
+
+	res := WarResolution{
+		WarID:      "war",
+		Attacker:   "alice",
+		Defender:   "bob",
+		Location:   "europe",
+		Casualties: map[string][]int{"bob": {1}},
+	}
+	// the attacker alone can not take bob's units off the server's records
+	w.HandleWarAck(WarAck{WarID: "war", Username: "alice", Agreed: true})
+	if w.HandleWarResolution(res) {
+		t.Fatal("expected no units removed before bob agreed")
+	}
+	if w.HandleWarAck(WarAck{WarID: "war", Username: "bob", Agreed: false}) {
+		t.Fatal("expected a dispute to remove nothing")
+	}
+	if !w.HandleWarAck(WarAck{WarID: "war", Username: "bob", Agreed: true}) {
+		t.Fatal("expected bob's casualties to be removed once it agreed")
+	}
+	changes := w.UpdateControl()
+	want := Territory{Location: "europe", Owner: "alice"}
+	if len(changes) != 1 || changes[0].Territory != want {
+		t.Errorf("expected alice to hold europe uncontested, got %+v", changes)
+	}
+	if _, ok := w.resolutions["war"]; ok {
+		t.Error("expected the war to be forgotten once everyone agreed")
+	}
+}
+
+func TestWarsNobodyFinishesAgreeingToExpire(t *testing.T) {
+	w := NewWorld("r1")
+	for _, username := range []string{"alice", "bob"} {
+		w.HandlePlayerState(Player{Username: username, Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		}})
+	}
+	w.HandleWarResolution(WarResolution{WarID: "war", Attacker: "alice", Defender: "bob", Location: "europe"})
+	w.HandleWarAck(WarAck{WarID: "war", Username: "alice", Agreed: true})
+	// an ack whose resolution never arrives
+	w.HandleWarAck(WarAck{WarID: "lost", Username: "carol", Agreed: true})
+
+	w.ExpireWars()
+	if _, ok := w.resolutions["war"]; !ok {
+		t.Fatal("expected the war to wait for bob a while")
+	}
+	for warID := range w.warsSeen {
+		w.warsSeen[warID] = time.Now().Add(-WarAgreementTimeout - time.Second)
+	}
+	w.ExpireWars()
+	if len(w.resolutions) != 0 || len(w.agreed) != 0 || len(w.warsSeen) != 0 {
+		t.Errorf("expected every war to be forgotten, got %v %v %v", w.resolutions, w.agreed, w.warsSeen)
+	}
+}
+
+func TestWarsDoNotWaitOnPlayersThatLeft(t *testing.T) {
+	w := NewWorld("r1")
+	for _, username := range []string{"alice", "bob"} {
+		w.HandlePlayerState(Player{Username: username, Units: map[int]Unit{
+			1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		}})
+	}
+	w.HandleWarResolution(WarResolution{WarID: "war", Attacker: "alice", Defender: "bob", Location: "europe"})
+	w.HandleWarAck(WarAck{WarID: "war", Username: "alice", Agreed: true})
+
+	w.RemovePlayer("bob")
+	if _, ok := w.resolutions["war"]; ok {
+		t.Error("expected the war to be forgotten once bob left")
+	}
+}
+
+func TestResupplyAppliesAttrition(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "antarctica"},
+		2: {ID: 2, Rank: RankInfantry, Location: "antarctica"},
+		3: {ID: 3, Rank: RankInfantry, Location: "antarctica"},
+	}})
+	// only wounds the server dealt count
+	w.players["alice"].Units[3] = Unit{ID: 3, Rank: RankInfantry, Location: "antarctica", HP: 3}
+	w.UpdateControl()
+
+	w.Resupply()
+	if _, ok := w.players["alice"].Units[3]; ok {
+		t.Error("expected the unit lost to attrition to be gone from the server's records")
+	}
+	if got := len(w.players["alice"].Units); got != 2 {
+		t.Errorf("expected 2 units left, got %v", got)
+	}
+}
@@ -0,0 +1,38 @@
+package gamelogic
+
+import "fmt"
+
+// MaxHP is the health of an unhurt unit of the unit's rank.
+func (u Unit) MaxHP() int {
+	return getAllRankHP()[u.Rank]
+}
+
+// health is what the unit has left, units that never took damage may not
+// have their HP set.
+func (u Unit) health() int {
+	if u.HP <= 0 {
+		return u.MaxHP()
+	}
+	return u.HP
+}
+
+// Veterancy is the highest promotion the unit's XP earned it.
+func (u Unit) Veterancy() UnitVeterancy {
+	veterancy := UnitVeterancy(VeterancyRecruit)
+	best := 0
+	for v, threshold := range getVeterancyThresholds() {
+		if u.XP >= threshold && threshold >= best {
+			veterancy = v
+			best = threshold
+		}
+	}
+	return veterancy
+}
+
+// Title names the unit after its promotion, e.g. veteran infantry.
+func (u Unit) Title() string {
+	if u.Veterancy() == VeterancyRecruit {
+		return string(u.Rank)
+	}
+	return fmt.Sprintf("%s %s", u.Veterancy(), u.Rank)
+}
@@ -0,0 +1,80 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestResolveWarWoundsAndTrainsSurvivors(t *testing.T) {
+	rw := RecognitionOfWar{
+		ID: "war",
+		Attacker: Player{
+			Username: "attacker",
+			Units:    map[int]Unit{4: {ID: 4, Rank: RankArtillery, Location: "europe", HP: 20, XP: 2}},
+		},
+		Defender: Player{
+			Username: "defender",
+			Units: map[int]Unit{
+				1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+				2: {ID: 2, Rank: RankInfantry, Location: "europe"},
+			},
+		},
+		Model: CombatModelPower,
+		Rules: DefaultCombatConfig(),
+	}
+
+	res, _ := resolveWar(rw)
+	// two infantry have 1.5 power against artillery, which rounds to 2 HP
+	want := map[string][]Unit{"attacker": {{ID: 4, Rank: RankArtillery, Location: "europe", HP: 18, XP: 4}}}
+	if !reflect.DeepEqual(res.Survivors, want) {
+		t.Errorf("expected survivors %v, got %v", want, res.Survivors)
+	}
+	if got := res.Survivors["attacker"][0].Veterancy(); got != VeterancyVeteran {
+		t.Errorf("expected the artillery to be promoted to veteran, got %s", got)
+	}
+}
+
+func TestSpreadDamageSparesTheLastUnit(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	units := []Unit{
+		{ID: 1, Rank: RankArtillery},
+		{ID: 2, Rank: RankInfantry, HP: 4},
+	}
+
+	got := calc.spreadDamage(units, 50)
+	want := map[int]int{2: 4, 1: 19}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected wounds %v, got %v", want, got)
+	}
+}
+
+func TestDiceBattleHitsUntilAUnitRunsOutOfHP(t *testing.T) {
+	calc := NewCombatCalculator(neutralCombatConfig())
+	attackers := []Unit{{ID: 1, Rank: RankInfantry}}
+	defenders := []Unit{{ID: 1, Rank: RankArtillery}}
+
+	for seed := int64(0); seed < 20; seed++ {
+		report, _, defenderLosses := calc.fightDiceBattle(seed, "europe", attackers, defenders)
+		hits := 0
+		for _, round := range report.Rounds {
+			hits += len(round.DefenderHits)
+		}
+		if len(defenderLosses) == 1 && hits != 2 {
+			t.Fatalf("seed %v: expected artillery to take two hits before it is lost, got %v", seed, hits)
+		}
+	}
+}
+
+func TestPowerAppliesVeterancy(t *testing.T) {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	opponents := []Unit{{ID: 1, Rank: RankCavalry}}
+
+	veteran := []Unit{{ID: 1, Rank: RankCavalry, XP: 3}}
+	if got := calc.Power(veteran, opponents, "europe", false); got != 6.25 {
+		t.Errorf("expected veteran cavalry power 6.25, got %v", got)
+	}
+	elite := []Unit{{ID: 1, Rank: RankCavalry, XP: 10}}
+	if got := calc.Power(elite, opponents, "europe", false); got != 7.5 {
+		t.Errorf("expected elite cavalry power 7.5, got %v", got)
+	}
+}
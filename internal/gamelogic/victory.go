@@ -0,0 +1,209 @@
+package gamelogic
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type VictoryKind string
+
+const (
+	VictoryElimination = "elimination"
+	VictoryControl     = "control"
+	VictoryScore       = "score"
+)
+
+// VictoryConfig is the condition the server checks to end the game.
+// Locations and Turns are used by control victories, TimeLimit by score
+// victories.
+type VictoryConfig struct {
+	Kind      VictoryKind
+	Locations int
+	Turns     int
+	TimeLimit time.Duration
+}
+
+type Standing struct {
+	Username  string
+	Score     int
+	Units     int
+	Locations int
+}
+
+type GameOver struct {
+	Winner    string
+	Reason    string
+	Standings []Standing
+	EndedAt   time.Time
+}
+
+const locationScore = 10
+
+func DefaultVictoryConfig() VictoryConfig {
+	return VictoryConfig{
+		Kind: VictoryElimination,
+	}
+}
+
+// ParseVictoryConfig reads the arguments of the server's victory command.
+func ParseVictoryConfig(words []string) (VictoryConfig, error) {
+	usage := errors.New("usage: victory elimination | victory control <locations> <turns> | victory score <minutes>")
+	if len(words) < 2 {
+		return VictoryConfig{}, usage
+	}
+	switch words[1] {
+	case VictoryElimination:
+		return VictoryConfig{Kind: VictoryElimination}, nil
+	case VictoryControl:
+		if len(words) < 4 {
+			return VictoryConfig{}, usage
+		}
+		locations, err := strconv.Atoi(words[2])
+		if err != nil || locations < 1 || locations > len(getAllLocations()) {
+			return VictoryConfig{}, fmt.Errorf("error: %s is not a valid number of locations", words[2])
+		}
+		turns, err := strconv.Atoi(words[3])
+		if err != nil || turns < 1 {
+			return VictoryConfig{}, fmt.Errorf("error: %s is not a valid number of turns", words[3])
+		}
+		return VictoryConfig{Kind: VictoryControl, Locations: locations, Turns: turns}, nil
+	case VictoryScore:
+		if len(words) < 3 {
+			return VictoryConfig{}, usage
+		}
+		minutes, err := strconv.Atoi(words[2])
+		if err != nil || minutes < 1 {
+			return VictoryConfig{}, fmt.Errorf("error: %s is not a valid number of minutes", words[2])
+		}
+		return VictoryConfig{Kind: VictoryScore, TimeLimit: time.Duration(minutes) * time.Minute}, nil
+	default:
+		return VictoryConfig{}, usage
+	}
+}
+
+func (vc VictoryConfig) String() string {
+	switch vc.Kind {
+	case VictoryControl:
+		return fmt.Sprintf("control %v locations for %v turns", vc.Locations, vc.Turns)
+	case VictoryScore:
+		return fmt.Sprintf("highest score after %v", vc.TimeLimit)
+	default:
+		return "eliminate all opponents"
+	}
+}
+
+func (gs *GameState) HandleGameOver(over GameOver) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== Game Over ====")
+	gs.pauseGame()
+	if over.Winner == gs.GetUsername() {
+		fmt.Println("You won the game!")
+	} else {
+		fmt.Printf("%s won the game!\n", over.Winner)
+	}
+	fmt.Println(over.Reason)
+	PrintStandings(over.Standings)
+}
+
+func PrintStandings(standings []Standing) {
+	fmt.Println("Final standings:")
+	for i, standing := range standings {
+		fmt.Printf("%v. %s: %v points, %v unit(s), %v location(s)\n", i+1, standing.Username, standing.Score, standing.Units, standing.Locations)
+	}
+}
+
+// scoreFor is the power of the player's units plus what the locations it
+// controls are worth.
+func scoreFor(p Player, controlled int) int {
+	calc := NewCombatCalculator(DefaultCombatConfig())
+	units := []Unit{}
+	for _, unit := range p.Units {
+		units = append(units, unit)
+	}
+	return int(calc.Power(units, nil, "", false)) + locationScore*controlled
+}
+
+// standings must be called with the lock held.
+func (w *World) standings() []Standing {
+	standings := []Standing{}
+	for _, username := range w.usernames() {
+		p := w.players[username]
+		controlled := len(w.controlledBy(username))
+		standings = append(standings, Standing{
+			Username:  username,
+			Score:     scoreFor(p, controlled),
+			Units:     len(p.Units),
+			Locations: controlled,
+		})
+	}
+	sort.SliceStable(standings, func(i, j int) bool { return standings[i].Score > standings[j].Score })
+	return standings
+}
+
+// CheckVictory ends the game once its victory condition is met. It only
+// reports a game over once.
+func (w *World) CheckVictory() (GameOver, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.over {
+		return GameOver{}, false
+	}
+
+	over := GameOver{
+		Standings: w.standings(),
+		EndedAt:   time.Now(),
+	}
+	switch w.victory.Kind {
+	case VictoryElimination:
+		// a game only starts once at least two players have fielded units
+		alive := []string{}
+		for _, username := range w.usernames() {
+			if len(w.players[username].Units) > 0 {
+				alive = append(alive, username)
+			}
+		}
+		if len(w.fielded) < 2 || len(alive) != 1 {
+			return GameOver{}, false
+		}
+		over.Winner = alive[0]
+		over.Reason = fmt.Sprintf("%s eliminated all opponents.", over.Winner)
+	case VictoryControl:
+		winner := ""
+		for _, username := range w.usernames() {
+			if w.controlStreaks[username] >= w.victory.Turns {
+				winner = username
+				break
+			}
+		}
+		if winner == "" {
+			return GameOver{}, false
+		}
+		over.Winner = winner
+		over.Reason = fmt.Sprintf("%s controlled %v locations for %v turns.", winner, w.victory.Locations, w.victory.Turns)
+	case VictoryScore:
+		if time.Since(w.startedAt) < w.victory.TimeLimit || len(over.Standings) == 0 {
+			return GameOver{}, false
+		}
+		over.Winner = over.Standings[0].Username
+		over.Reason = fmt.Sprintf("%s had the highest score after %v.", over.Winner, w.victory.TimeLimit)
+	default:
+		return GameOver{}, false
+	}
+	w.over = true
+	return over, true
+}
+
+// advanceControlStreaks must be called with the lock held, once per turn.
+func (w *World) advanceControlStreaks() {
+	for _, username := range w.usernames() {
+		if w.victory.Kind == VictoryControl && len(w.controlledBy(username)) >= w.victory.Locations {
+			w.controlStreaks[username]++
+		} else {
+			w.controlStreaks[username] = 0
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package gamelogic
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVictoryConfig(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    VictoryConfig
+		wantErr bool
+	}{
+		{"victory elimination", VictoryConfig{Kind: VictoryElimination}, false},
+		{"victory control 3 2", VictoryConfig{Kind: VictoryControl, Locations: 3, Turns: 2}, false},
+		{"victory score 10", VictoryConfig{Kind: VictoryScore, TimeLimit: 10 * time.Minute}, false},
+		{"victory", VictoryConfig{}, true},
+		{"victory control 3", VictoryConfig{}, true},
+		{"victory control 99 2", VictoryConfig{}, true},
+		{"victory control 3 0", VictoryConfig{}, true},
+		{"victory score zero", VictoryConfig{}, true},
+		{"victory conquest", VictoryConfig{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseVictoryConfig(strings.Fields(tt.input))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tt.input, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q: expected %+v, got %+v", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestEliminationVictory(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	if _, over := w.CheckVictory(); over {
+		t.Fatal("expected a game with one player to go on")
+	}
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{}})
+
+	over, ok := w.CheckVictory()
+	if !ok || over.Winner != "alice" {
+		t.Fatalf("expected alice to win, got %+v %v", over, ok)
+	}
+	if _, ok := w.CheckVictory(); ok {
+		t.Error("expected the game over to be reported once")
+	}
+}
+
+func TestScoreTimeLimitCountsFromSetVictory(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	// the room has been up for a while before the limit is set
+	w.startedAt = time.Now().Add(-time.Hour)
+	w.SetVictory(VictoryConfig{Kind: VictoryScore, TimeLimit: 10 * time.Minute})
+	if _, over := w.CheckVictory(); over {
+		t.Fatal("expected the time limit to start when it was set")
+	}
+
+	w.startedAt = time.Now().Add(-11 * time.Minute)
+	over, ok := w.CheckVictory()
+	if !ok || over.Winner != "alice" {
+		t.Errorf("expected alice to win on score, got %+v %v", over, ok)
+	}
+}
+
+func TestLeavingDoesNotEndAnEliminationGame(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}})
+
+	w.RemovePlayer("bob")
+	if over, ok := w.CheckVictory(); ok {
+		t.Fatalf("expected the game to wait for a new opponent, got %+v", over)
+	}
+
+	w.HandlePlayerState(Player{Username: "carol", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}})
+	w.HandlePlayerState(Player{Username: "carol", Units: map[int]Unit{}})
+	if over, ok := w.CheckVictory(); !ok || over.Winner != "alice" {
+		t.Errorf("expected alice to win against carol, got %+v %v", over, ok)
+	}
+}
@@ -0,0 +1,45 @@
+package gamelogic
+
+// canSee reports whether a player has units in or next to a location.
+func canSee(p Player, loc Location) bool {
+	adjacent := getAdjacentLocations()
+	for _, unit := range p.Units {
+		if unit.Location == loc {
+			return true
+		}
+		for _, neighbor := range adjacent[unit.Location] {
+			if neighbor == loc {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Witnesses are the players, other than the one moving, that can see where
+// a move went. Only they are told about it.
+func (w *World) Witnesses(mv ArmyMove) []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	witnesses := []string{}
+	for _, username := range w.usernames() {
+		if username != mv.Player.Username && canSee(w.players[username], mv.ToLocation) {
+			witnesses = append(witnesses, username)
+		}
+	}
+	return witnesses
+}
+
+// playerInLocation strips a player down to the units others can see in loc.
+func playerInLocation(p Player, loc Location) Player {
+	units := map[int]Unit{}
+	for id, unit := range p.Units {
+		if unit.Location == loc {
+			units[id] = unit
+		}
+	}
+	return Player{
+		Username: p.Username,
+		Units:    units,
+	}
+}
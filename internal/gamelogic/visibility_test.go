@@ -0,0 +1,33 @@
+package gamelogic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWitnessesAreThePlayersThatCanSeeTheMove(t *testing.T) {
+	w := NewWorld("r1")
+	w.HandlePlayerState(Player{Username: "alice", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	// next to europe
+	w.HandlePlayerState(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "asia"},
+	}})
+	// in europe
+	w.HandlePlayerState(Player{Username: "carol", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}})
+	// far away
+	w.HandlePlayerState(Player{Username: "dave", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "australia"},
+	}})
+
+	mv := ArmyMove{
+		Player:     Player{Username: "alice"},
+		ToLocation: "europe",
+	}
+	if got, want := w.Witnesses(mv), []string{"bob", "carol"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v to witness the move, got %v", want, got)
+	}
+}
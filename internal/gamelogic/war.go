@@ -2,6 +2,11 @@ package gamelogic
 
 import (
 	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
+	"time"
 )
 
 type WarOutcome int
@@ -12,94 +17,496 @@ const (
 	WarOutcomeYouWon
 	WarOutcomeOpponentWon
 	WarOutcomeDraw
+	WarOutcomeAwaitingDecisions
+	WarOutcomeAwaitingResolution
+	WarOutcomeAwaitingRecognition
 )
 
-func (gs *GameState) HandleWar(rw RecognitionOfWar) (outcome WarOutcome, winner string, loser string) {
+// NewRecognitionOfWar is called by the defender when an attacker moves into
+// one of its locations. Every other player in the battlefield is pulled into
+// the war, allies at the defender's side and everyone else for themselves.
+// ok is false when another player there leads the defense and declares the
+// war instead.
+func (gs *GameState) NewRecognitionOfWar(mv ArmyMove, bf Battlefield) (rw RecognitionOfWar, ok bool) {
+	attacker := mv.Player
+	defender := gs.GetPlayerSnap()
+	// the attacker only gets to see the defender's units in the battlefield
+	loc := getOverlappingLocation(attacker, defender)
+	defender = playerInLocation(defender, loc)
+	switch leader := bf.warLeader(defender.Username, attacker.Username); leader {
+	case defender.Username:
+	case "":
+		fmt.Printf("%s attacks %s, but the server knows you are at peace with it.\n", attacker.Username, loc)
+		return RecognitionOfWar{}, false
+	default:
+		fmt.Printf("%s attacks %s! %s declares the war for everyone there.\n", attacker.Username, loc, leader)
+		return RecognitionOfWar{}, false
+	}
+	fmt.Printf("You are at war with %s in %s!\n", attacker.Username, loc)
+	model, rules := gs.agreedCombatRules(mv.Model, mv.Rules)
+	now := time.Now().UnixNano()
+	return RecognitionOfWar{
+		ID:       fmt.Sprintf("%s-%s-%d", attacker.Username, defender.Username, now),
+		Attacker: attacker,
+		Defender: defender,
+		Allies:   bf.alliesIn(defender.Username, attacker.Username),
+		Others:   bf.othersIn(defender.Username, attacker.Username),
+		Location: loc,
+		DecideBy: time.Now().Add(WarDecisionWindow),
+		Model:    model,
+		Rules:    rules,
+		Seed:     now,
+		CutOff:   bf.CutOff,
+	}, true
+}
+
+// HandleWar is run by every participant when a war is recognized. The
+// attacker waits for everyone else's decision before fighting it, everyone
+// else gets to decide and keeps the recognition so it can check the
+// attacker's result.
+func (gs *GameState) HandleWar(rw RecognitionOfWar) WarOutcome {
 	defer fmt.Println("------------------------")
 	fmt.Println()
 	fmt.Println("==== War Declared ====")
 	fmt.Printf("%s has declared war on %s!\n", rw.Attacker.Username, rw.Defender.Username)
 
-	player := gs.GetPlayerSnap()
+	username := gs.GetUsername()
 
-	if player.Username == rw.Defender.Username {
-		fmt.Printf("%s, you published the war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	if !slices.Contains(rw.Participants(), username) {
+		fmt.Printf("%s, you are not involved in this war.\n", username)
+		return WarOutcomeNotInvolved
 	}
-
-	if player.Username != rw.Attacker.Username {
-		fmt.Printf("%s, you are not involved in this war.\n", player.Username)
-		return WarOutcomeNotInvolved, "", ""
+	for _, ally := range rw.Allies {
+		fmt.Printf("%s fights at %s's side!\n", ally.Username, rw.Defender.Username)
+	}
+	for _, other := range rw.Others {
+		fmt.Printf("%s is caught in the battle and fights for itself!\n", other.Username)
 	}
 
-	overlappingLocation := getOverlappingLocation(rw.Attacker, rw.Defender)
-	if overlappingLocation == "" {
+	res, ok := resolveWar(rw)
+	if !ok {
 		fmt.Printf("Error! No units are in the same location. No war will be fought.\n")
-		return WarOutcomeNoUnits, "", ""
+		return WarOutcomeNoUnits
+	}
+
+	if username != rw.Attacker.Username {
+		gs.addPendingWar(rw)
+		gs.addUndecidedWar(rw)
+		fmt.Printf("You have %v to decide what your units in %s do:\n", time.Until(rw.DecideBy).Round(time.Second), res.Location)
+		fmt.Println("fight, retreat <location>, surrender or reinforce <unitID> <unitID>...")
+		fmt.Println("Your units fight if you don't decide in time.")
+		return WarOutcomeAwaitingResolution
 	}
 
-	attackerUnits := []Unit{}
-	defenderUnits := []Unit{}
-	for _, unit := range rw.Attacker.Units {
-		if unit.Location == overlappingLocation {
-			attackerUnits = append(attackerUnits, unit)
+	// the defender only picks the rules the attacker picked too
+	if !gs.acceptsCombatRules(rw.Model, rw.Rules) {
+		fmt.Printf("%s picked combat rules you did not agree to, the war is fought with the defaults.\n", rw.Defender.Username)
+		rw.Model = CombatModelPower
+		rw.Rules = DefaultCombatConfig()
+	}
+	gs.addOpenWar(rw)
+	fmt.Printf("Waiting for %v to decide...\n", rw.Participants()[1:])
+	return WarOutcomeAwaitingDecisions
+}
+
+// HandleWarResolution applies the player's casualties and accepted decision
+// from a resolution and returns the ack to send to every other participant,
+// and the move of units that retreated or reinforced. Resolutions and
+// recognitions arrive on different queues, so a resolution that overtook its
+// recognition is held until the recognition arrives to check it against.
+func (gs *GameState) HandleWarResolution(res WarResolution) (WarOutcome, WarAck, ArmyMove) {
+	defer fmt.Println("------------------------")
+	fmt.Println()
+	fmt.Println("==== War Resolved ====")
+	fmt.Printf("The war between %v in %s is over.\n", res.Participants(), res.Location)
+
+	username := gs.GetUsername()
+
+	if !slices.Contains(res.Participants(), username) {
+		fmt.Printf("%s, you are not involved in this war.\n", username)
+		return WarOutcomeNotInvolved, WarAck{}, ArmyMove{}
+	}
+
+	// the attacker resolved the war itself, everyone else checks it
+	agreed := username == res.Attacker
+	if !agreed {
+		rw, ok := gs.popPendingWar(res.WarID)
+		if !ok {
+			gs.holdResolution(res)
+			fmt.Println("Waiting for the war's recognition to check the resolution against...")
+			return WarOutcomeAwaitingRecognition, WarAck{}, ArmyMove{}
 		}
+		expected, _ := resolveWarWithDecisions(rw, res.Decisions)
+		agreed = reflect.DeepEqual(expected, res)
+	}
+	gs.forgetUndecidedWar(res.WarID)
+
+	for _, d := range res.Decisions {
+		printWarDecision(d)
+	}
+	for _, battle := range res.Battles {
+		fmt.Printf("%s (power %.1f) fought %s (power %.1f): ", battle.Attacker, battle.AttackerPower, battle.Defender, battle.DefenderPower)
+		if battle.Winner == "" {
+			fmt.Println("nobody won")
+		} else {
+			fmt.Printf("%s won\n", battle.Winner)
+		}
+		printBattleReport(battle.Report)
+	}
+
+	if !agreed {
+		fmt.Printf("Warning! %s's resolution does not match the war you recognized.\n", res.Attacker)
 	}
-	for _, unit := range rw.Defender.Units {
-		if unit.Location == overlappingLocation {
-			defenderUnits = append(defenderUnits, unit)
+
+	for other, lost := range res.Casualties {
+		if other != username {
+			gs.forgetEnemyUnits(other, lost)
 		}
 	}
 
-	fmt.Printf("%s's units:\n", rw.Attacker.Username)
-	for _, unit := range attackerUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	lost := res.Casualties[username]
+	gs.removeUnits(lost)
+	if len(lost) > 0 {
+		fmt.Printf("%v of your units in %s have been killed: %v\n", len(lost), res.Location, lost)
+	}
+	gs.applySurvivors(res.Survivors[username])
+	mv := gs.applyAcceptedDecision(res)
+	gs.addUnconfirmedWar(res)
+
+	ack := WarAck{
+		WarID:    res.WarID,
+		Username: username,
+		Agreed:   agreed,
 	}
-	fmt.Printf("%s's units:\n", rw.Defender.Username)
-	for _, unit := range defenderUnits {
-		fmt.Printf("  * %v\n", unit.Rank)
+	switch res.Winner {
+	case "":
+		return WarOutcomeDraw, ack, mv
+	case res.SideOf(username):
+		return WarOutcomeYouWon, ack, mv
+	default:
+		return WarOutcomeOpponentWon, ack, mv
+	}
+}
+
+// DropHeldResolution gives up on a resolution whose recognition never
+// arrived. Without it the resolution can't be checked, so nothing of it is
+// applied and the returned ack disputes it.
+func (gs *GameState) DropHeldResolution(id string) (WarResolution, WarAck, bool) {
+	res, ok := gs.PopHeldResolution(id)
+	if !ok {
+		return WarResolution{}, WarAck{}, false
 	}
-	attackerPower := unitsToPowerLevel(attackerUnits)
-	defenderPower := unitsToPowerLevel(defenderUnits)
-	fmt.Printf("Attacker has a power level of %v\n", attackerPower)
-	fmt.Printf("Defender has a power level of %v\n", defenderPower)
-	if attackerPower > defenderPower {
-		fmt.Printf("%s has won the war!\n", rw.Attacker.Username)
-		if player.Username == rw.Defender.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Attacker.Username, rw.Defender.Username
+	gs.popSentDecision(id)
+	fmt.Println()
+	fmt.Printf("Warning! %s resolved a war in %s you never saw recognized, the resolution was dropped.\n", res.Attacker, res.Location)
+	return res, WarAck{
+		WarID:    res.WarID,
+		Username: gs.GetUsername(),
+		Agreed:   false,
+	}, true
+}
+
+// applySurvivors updates the player's units that lived through a war with
+// their wounds and experience.
+func (gs *GameState) applySurvivors(survivors []Unit) {
+	for _, unit := range survivors {
+		old, ok := gs.GetUnit(unit.ID)
+		if !ok {
+			continue
+		}
+		gs.UpdateUnit(unit)
+		if unit.health() < old.health() {
+			fmt.Printf("Your %s %v was wounded, %v/%v HP left.\n", unit.Title(), unit.ID, unit.health(), unit.MaxHP())
 		}
-		return WarOutcomeYouWon, rw.Attacker.Username, rw.Defender.Username
-	} else if defenderPower > attackerPower {
-		fmt.Printf("%s has won the war!\n", rw.Defender.Username)
-		if player.Username == rw.Attacker.Username {
-			fmt.Println("You have lost the war!")
-			gs.removeUnitsInLocation(overlappingLocation)
-			fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-			return WarOutcomeOpponentWon, rw.Defender.Username, rw.Attacker.Username
+		if unit.Veterancy() != old.Veterancy() {
+			fmt.Printf("Your %s %v was promoted to %s!\n", unit.Rank, unit.ID, unit.Veterancy())
 		}
-		return WarOutcomeYouWon, rw.Defender.Username, rw.Attacker.Username
 	}
-	fmt.Println("The war ended in a draw!")
-	fmt.Printf("Your units in %s have been killed.\n", overlappingLocation)
-	gs.removeUnitsInLocation(overlappingLocation)
-	return WarOutcomeDraw, rw.Attacker.Username, rw.Defender.Username
 }
 
-func unitsToPowerLevel(units []Unit) int {
-	power := 0
-	for _, unit := range units {
-		if unit.Rank == RankArtillery {
-			power += 10
+// HandleWarAck confirms that another participant applied the same resolution.
+func (gs *GameState) HandleWarAck(ack WarAck) bool {
+	fmt.Println()
+	res, done, ok := gs.ackUnconfirmedWar(ack.WarID, ack.Username)
+	if !ok {
+		fmt.Printf("Received an acknowledgment from %s for an unknown war.\n", ack.Username)
+		return false
+	}
+	if !ack.Agreed {
+		fmt.Printf("%s disputes the outcome of the war in %s!\n", ack.Username, res.Location)
+		return false
+	}
+	fmt.Printf("%s confirmed the outcome of the war in %s.\n", ack.Username, res.Location)
+	if done && len(res.Participants()) > 2 {
+		fmt.Println("Every participant agrees on the outcome.")
+	}
+	return true
+}
+
+// players are every player fighting in the war, the attacker first.
+func (rw RecognitionOfWar) players() []Player {
+	players := append([]Player{rw.Attacker, rw.Defender}, rw.Allies...)
+	return append(players, rw.Others...)
+}
+
+// Participants are the usernames of every player fighting in the war.
+func (rw RecognitionOfWar) Participants() []string {
+	participants := []string{}
+	for _, p := range rw.players() {
+		participants = append(participants, p.Username)
+	}
+	return participants
+}
+
+// Participants are every player that fought in the war.
+func (res WarResolution) Participants() []string {
+	participants := append([]string{res.Attacker, res.Defender}, res.Allies...)
+	return append(participants, res.Others...)
+}
+
+// SideOf is the leader of the side username fought on, allies fight for the
+// defender.
+func (res WarResolution) SideOf(username string) string {
+	if slices.Contains(res.Allies, username) {
+		return res.Defender
+	}
+	return username
+}
+
+// warSide is every unit fighting for one side of a war. Unit IDs are only
+// unique per player, so units are numbered by their position for the fight
+// and mapped back to their owners' IDs afterwards.
+type warSide struct {
+	leader string
+	units  []Unit
+	owners []string
+	ids    []int
+	cutOff map[int]bool
+	fought bool
+}
+
+// newWarSide puts the players' units in loc on one side. Units of the
+// players in cutOff fight out of supply.
+func newWarSide(loc Location, cutOff []string, players ...Player) warSide {
+	side := warSide{
+		leader: players[0].Username,
+		cutOff: map[int]bool{},
+	}
+	for _, p := range players {
+		for _, unit := range unitsInLocation(p, loc) {
+			side.owners = append(side.owners, p.Username)
+			side.ids = append(side.ids, unit.ID)
+			unit.ID = len(side.units) + 1
+			unit.HP = unit.health()
+			side.cutOff[unit.ID] = slices.Contains(cutOff, p.Username)
+			side.units = append(side.units, unit)
 		}
-		if unit.Rank == RankCavalry {
-			power += 5
+	}
+	return side
+}
+
+// addCasualties maps losses, numbered by position, back to each owner's unit IDs.
+func (side warSide) addCasualties(casualties map[string][]int, losses []int) {
+	for _, loss := range losses {
+		owner := side.owners[loss-1]
+		casualties[owner] = append(casualties[owner], side.ids[loss-1])
+	}
+}
+
+// hurt returns the side after its wounds, and the units that died of them.
+func (side warSide) hurt(damage map[int]int) (warSide, []int) {
+	survivors := []Unit{}
+	losses := []int{}
+	for _, unit := range side.units {
+		unit.HP -= damage[unit.ID]
+		if unit.HP <= 0 {
+			losses = append(losses, unit.ID)
+			continue
+		}
+		survivors = append(survivors, unit)
+	}
+	side.units = survivors
+	side.fought = true
+	return side, losses
+}
+
+// learn gives every unit still standing xp.
+func (side warSide) learn(xp int) {
+	for i := range side.units {
+		side.units[i].XP += xp
+	}
+}
+
+// addSurvivors maps the units that fought and lived back to their owners.
+func (side warSide) addSurvivors(survivors map[string][]Unit) {
+	if !side.fought {
+		return
+	}
+	for _, unit := range side.units {
+		owner := side.owners[unit.ID-1]
+		unit.ID = side.ids[unit.ID-1]
+		survivors[owner] = append(survivors[owner], unit)
+	}
+}
+
+// lethal is the damage that wipes out the side.
+func (side warSide) lethal() map[int]int {
+	damage := map[int]int{}
+	for _, unit := range side.units {
+		damage[unit.ID] = unit.health()
+	}
+	return damage
+}
+
+// resolveWar fights the war as a series of battles. The attacker fights the
+// defender and its allies first, then whoever holds the battlefield fights
+// each of the others in turn. A side that is wiped out hands the field to
+// the next one.
+func resolveWar(rw RecognitionOfWar) (WarResolution, bool) {
+	// the defender may have retreated, so only fall back to where both
+	// sides are for recognitions that don't name the battlefield
+	overlappingLocation := rw.Location
+	if overlappingLocation == "" {
+		overlappingLocation = getOverlappingLocation(rw.Attacker, rw.Defender)
+	}
+	if overlappingLocation == "" {
+		return WarResolution{}, false
+	}
+
+	rules := rw.Rules
+	if rules.RankPower == nil {
+		rules = DefaultCombatConfig()
+	}
+	calc := NewCombatCalculator(rules)
+
+	sides := []warSide{
+		newWarSide(overlappingLocation, rw.CutOff, rw.Attacker),
+		newWarSide(overlappingLocation, rw.CutOff, append([]Player{rw.Defender}, rw.Allies...)...),
+	}
+	for _, other := range rw.Others {
+		sides = append(sides, newWarSide(overlappingLocation, rw.CutOff, other))
+	}
+
+	res := WarResolution{
+		WarID:      rw.ID,
+		Attacker:   rw.Attacker.Username,
+		Defender:   rw.Defender.Username,
+		Location:   overlappingLocation,
+		Casualties: map[string][]int{},
+		Survivors:  map[string][]Unit{},
+	}
+	for _, ally := range rw.Allies {
+		res.Allies = append(res.Allies, ally.Username)
+	}
+	for _, other := range rw.Others {
+		res.Others = append(res.Others, other.Username)
+	}
+
+	holder := 0
+	for i := 1; i < len(sides); i++ {
+		if len(sides[holder].units) == 0 {
+			holder = i
+			continue
+		}
+		if len(sides[i].units) == 0 {
+			continue
+		}
+		// every battle gets its own dice, the first one keeps the war's seed
+		battle, attackerDamage, defenderDamage := calc.fight(rw.Model, rw.Seed+int64(i-1), overlappingLocation, sides[holder], sides[i])
+		res.Battles = append(res.Battles, battle)
+		var attackerLosses, defenderLosses []int
+		sides[holder], attackerLosses = sides[holder].hurt(attackerDamage)
+		sides[i], defenderLosses = sides[i].hurt(defenderDamage)
+		sides[holder].addCasualties(res.Casualties, attackerLosses)
+		sides[i].addCasualties(res.Casualties, defenderLosses)
+		sides[holder].learn(battleXP)
+		sides[i].learn(battleXP)
+		switch battle.Winner {
+		case sides[holder].leader:
+			sides[holder].learn(victoryXP)
+		case sides[i].leader:
+			sides[i].learn(victoryXP)
+		}
+		if len(sides[holder].units) == 0 && len(sides[i].units) > 0 {
+			holder = i
+		}
+	}
+
+	for _, side := range sides {
+		side.addSurvivors(res.Survivors)
+	}
+
+	standing := []string{}
+	for _, side := range sides {
+		if len(side.units) > 0 {
+			standing = append(standing, side.leader)
+		}
+	}
+	if len(standing) == 1 {
+		res.Winner = standing[0]
+	}
+	for _, side := range sides {
+		if len(side.units) == 0 || (res.Winner != "" && side.leader != res.Winner) {
+			res.Losers = append(res.Losers, side.leader)
+		}
+	}
+	return res, true
+}
+
+// fight is a single battle in which the side holding the battlefield
+// attacks the next one. It returns the HP each side's units lose, numbered
+// by their position. With the power model the losing side is wiped out and
+// the winner takes as much damage as the loser had power.
+func (c *CombatCalculator) fight(model CombatModel, seed int64, loc Location, attackers, defenders warSide) (battle Battle, attackerDamage, defenderDamage map[int]int) {
+	battle = Battle{
+		Attacker:      attackers.leader,
+		Defender:      defenders.leader,
+		AttackerPower: c.power(attackers.units, attackers.cutOff, defenders.units, loc, false),
+		DefenderPower: c.power(defenders.units, defenders.cutOff, attackers.units, loc, true),
+	}
+
+	if model == CombatModelDice {
+		var attackerLosses, defenderLosses []int
+		battle.Report, attackerLosses, defenderLosses = c.fightDiceBattle(seed, loc, attackers.units, defenders.units)
+		// the battle stops once a side is wiped out, anything else is a draw
+		if len(defenderLosses) == len(defenders.units) {
+			battle.Winner = attackers.leader
+		} else if len(attackerLosses) == len(attackers.units) {
+			battle.Winner = defenders.leader
 		}
-		if unit.Rank == RankInfantry {
-			power += 1
+		return battle, diceDamage(battle.Report, false), diceDamage(battle.Report, true)
+	}
+
+	if battle.AttackerPower > battle.DefenderPower {
+		battle.Winner = attackers.leader
+		attackerDamage = c.spreadDamage(attackers.units, int(math.Round(battle.DefenderPower)))
+		defenderDamage = defenders.lethal()
+	} else if battle.DefenderPower > battle.AttackerPower {
+		battle.Winner = defenders.leader
+		attackerDamage = attackers.lethal()
+		defenderDamage = c.spreadDamage(defenders.units, int(math.Round(battle.AttackerPower)))
+	} else {
+		attackerDamage = attackers.lethal()
+		defenderDamage = defenders.lethal()
+	}
+	return battle, attackerDamage, defenderDamage
+}
+
+func unitsInLocation(p Player, loc Location) []Unit {
+	units := []Unit{}
+	for _, unit := range p.Units {
+		if unit.Location == loc {
+			units = append(units, unit)
 		}
 	}
-	return power
+	sort.Slice(units, func(i, j int) bool { return units[i].ID < units[j].ID })
+	return units
+}
+
+func unitIDs(units []Unit) []int {
+	ids := []int{}
+	for _, unit := range units {
+		ids = append(ids, unit.ID)
+	}
+	return ids
 }
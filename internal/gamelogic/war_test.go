@@ -0,0 +1,80 @@
+package gamelogic
+
+import "testing"
+
+// newTestWar has attacker move into europe, where defender has a unit, and
+// returns the recognition and the attacker's resolution.
+func newTestWar(t *testing.T, defender *GameState) (RecognitionOfWar, WarResolution) {
+	t.Helper()
+	if err := defender.CommandSpawn([]string{"spawn", "europe", "infantry"}); err != nil {
+		t.Fatal(err)
+	}
+	attacker := Player{
+		Username: "attacker",
+		Units:    map[int]Unit{1: {ID: 1, Rank: RankArtillery, Location: "europe"}},
+	}
+	rw, ok := defender.NewRecognitionOfWar(ArmyMove{Player: attacker}, Battlefield{Location: "europe", Players: []Player{attacker}})
+	if !ok {
+		t.Fatal("expected the only defender to declare the war")
+	}
+	res, ok := resolveWarWithDecisions(rw, nil)
+	if !ok {
+		t.Fatal("expected the war to be fought")
+	}
+	return rw, res
+}
+
+func TestResolutionIsHeldUntilItsRecognitionArrives(t *testing.T) {
+	gs := NewGameState("defender")
+	rw, res := newTestWar(t, gs)
+
+	if got, _, _ := gs.HandleWarResolution(res); got != WarOutcomeAwaitingRecognition {
+		t.Fatalf("expected the resolution to wait for its recognition, got %v", got)
+	}
+	if _, ok := gs.GetUnit(1); !ok {
+		t.Fatal("expected nothing to be applied before the recognition arrived")
+	}
+
+	if got := gs.HandleWar(rw); got != WarOutcomeAwaitingResolution {
+		t.Fatalf("expected the defender to await the resolution, got %v", got)
+	}
+	held, ok := gs.PopHeldResolution(rw.ID)
+	if !ok {
+		t.Fatal("expected the resolution to be held")
+	}
+	_, ack, _ := gs.HandleWarResolution(held)
+	if !ack.Agreed {
+		t.Error("expected the resolution to match the recognition")
+	}
+	if _, err := gs.CommandDecide([]string{"fight"}); err == nil {
+		t.Error("expected the war to no longer wait on a decision")
+	}
+}
+
+func TestUnverifiedResolutionIsDisputed(t *testing.T) {
+	gs := NewGameState("defender")
+	_, res := newTestWar(t, gs)
+
+	gs.HandleWarResolution(res)
+	_, ack, ok := gs.DropHeldResolution(res.WarID)
+	if !ok {
+		t.Fatal("expected the resolution to be held")
+	}
+	if ack.Agreed {
+		t.Error("expected a resolution that can't be checked to be disputed")
+	}
+	if _, ok := gs.GetUnit(1); !ok {
+		t.Error("expected nothing of the dropped resolution to be applied")
+	}
+}
+
+func TestTamperedResolutionIsDisputed(t *testing.T) {
+	gs := NewGameState("defender")
+	rw, res := newTestWar(t, gs)
+	gs.HandleWar(rw)
+
+	res.Winner = "defender"
+	if _, ack, _ := gs.HandleWarResolution(res); ack.Agreed {
+		t.Error("expected a resolution that does not match the recognition to be disputed")
+	}
+}
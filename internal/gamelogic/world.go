@@ -0,0 +1,351 @@
+package gamelogic
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+)
+
+// World is the server's authoritative view of a game. Clients report their
+// full state to it and it keeps every player's treasury and decides when the
+// game is over.
+type World struct {
+	room       string
+	players    map[string]Player
+	treasuries map[string]int
+	pause      routing.PlayingState
+	// pauses of single players, a room wide pause or resume replaces them
+	playerPauses map[string]routing.PlayingState
+	victory      VictoryConfig
+	startedAt    time.Time
+	// players that have had units at some point
+	fielded map[string]struct{}
+	// where each player first fielded units, supply is traced back to it
+	homes map[string]Location
+	// locations each player's supply did not reach at the end of the last turn
+	cutOff map[string][]Location
+	// units the server took off each player, reporting them again does not
+	// bring them back
+	dead map[string]map[int]struct{}
+	// who controls each location
+	control map[Location]Territory
+	// consecutive turns each player has met a control victory
+	controlStreaks map[string]int
+	// players that lost connection, they keep everything until they are back
+	disconnected map[string]struct{}
+	// resolutions of wars the server overheard, and the participants that
+	// agreed to them, their casualties are gone from the server's records.
+	// Wars nobody finished agreeing to are forgotten a while after the
+	// server first heard of them.
+	resolutions map[string]WarResolution
+	agreed      map[string]map[string]struct{}
+	warsSeen    map[string]time.Time
+	// team of each player and the players each team invited
+	teams   map[string]string
+	invites map[string]map[string]struct{}
+	// treaties the server overheard being agreed, keyed by both players, and
+	// the proposals still open, keyed by proposer and receiver
+	treaties  map[string]map[string]Treaty
+	proposals map[string]map[string]DiplomacyMessage
+	over      bool
+	mu        *sync.RWMutex
+}
+
+func NewWorld(room string) *World {
+	return &World{
+		room:           room,
+		players:        map[string]Player{},
+		treasuries:     map[string]int{},
+		playerPauses:   map[string]routing.PlayingState{},
+		victory:        DefaultVictoryConfig(),
+		startedAt:      time.Now(),
+		fielded:        map[string]struct{}{},
+		homes:          map[string]Location{},
+		cutOff:         map[string][]Location{},
+		dead:           map[string]map[int]struct{}{},
+		control:        map[Location]Territory{},
+		controlStreaks: map[string]int{},
+		disconnected:   map[string]struct{}{},
+		resolutions:    map[string]WarResolution{},
+		agreed:         map[string]map[string]struct{}{},
+		warsSeen:       map[string]time.Time{},
+		teams:          map[string]string{},
+		invites:        map[string]map[string]struct{}{},
+		treaties:       map[string]map[string]Treaty{},
+		proposals:      map[string]map[string]DiplomacyMessage{},
+		mu:             &sync.RWMutex{},
+	}
+}
+
+// SetVictory changes the victory condition. Time limits count from the
+// change, not from when the room was created.
+func (w *World) SetVictory(vc VictoryConfig) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.victory = vc
+	w.startedAt = time.Now()
+	w.controlStreaks = map[string]int{}
+}
+
+func (w *World) GetVictory() VictoryConfig {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.victory
+}
+
+func (w *World) IsOver() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.over
+}
+
+func (w *World) Room() string {
+	return w.room
+}
+
+func (w *World) SetPaused(ps routing.PlayingState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pause = ps
+	w.playerPauses = map[string]routing.PlayingState{}
+}
+
+func (w *World) IsPaused() bool {
+	return w.PlayingState().IsPaused
+}
+
+// PlayingState is the room wide pause, a timed pause that ran out counts as resumed.
+func (w *World) PlayingState() routing.PlayingState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.pause.Expired() {
+		return routing.PlayingState{}
+	}
+	return w.pause
+}
+
+func (w *World) SetPlayerPaused(username string, ps routing.PlayingState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !ps.IsPaused {
+		delete(w.playerPauses, username)
+		return
+	}
+	w.playerPauses[username] = ps
+}
+
+// PlayerPlayingState is the pause a single player is under, if any.
+func (w *World) PlayerPlayingState(username string) routing.PlayingState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ps, ok := w.playerPauses[username]
+	if !ok || ps.Expired() {
+		return routing.PlayingState{}
+	}
+	return ps
+}
+
+// ExpirePause ends the room wide pause if it is still the timed pause that
+// expires at expiry. It reports whether the pause was ended.
+func (w *World) ExpirePause(expiry time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.pause.IsPaused || !w.pause.Expiry.Equal(expiry) {
+		return false
+	}
+	w.pause = routing.PlayingState{}
+	return true
+}
+
+// ExpirePlayerPause is ExpirePause for a single player's pause.
+func (w *World) ExpirePlayerPause(username string, expiry time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	ps, ok := w.playerPauses[username]
+	if !ok || !ps.Expiry.Equal(expiry) {
+		return false
+	}
+	delete(w.playerPauses, username)
+	return true
+}
+
+// HandlePlayerState records a player's reported state and charges it for any
+// units it spawned since its last report. Units it cannot afford are refused
+// and not recorded. It reports whether the player's mirror of its treasury
+// needs updating.
+func (w *World) HandlePlayerState(p Player) (TreasuryUpdate, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prev, ok := w.players[p.Username]
+	if _, known := w.treasuries[p.Username]; !known {
+		// a player that left and came back keeps what it had
+		w.treasuries[p.Username] = StartingTreasury
+	}
+	p, cost, refused := chargeSpawns(prev, w.reconcile(prev, p), w.treasuries[p.Username])
+	w.players[p.Username] = p
+	delete(w.disconnected, p.Username)
+	if len(p.Units) > 0 {
+		w.fielded[p.Username] = struct{}{}
+	}
+	if _, ok := w.homes[p.Username]; !ok && len(p.Units) > 0 {
+		// the player's oldest unit is where it started out
+		ids := []int{}
+		for id := range p.Units {
+			ids = append(ids, id)
+		}
+		w.homes[p.Username] = p.Units[slices.Min(ids)].Location
+	}
+
+	w.treasuries[p.Username] -= cost
+	tu := TreasuryUpdate{
+		Username: p.Username,
+		Balance:  w.treasuries[p.Username],
+	}
+	if cost > 0 {
+		tu.Reason = fmt.Sprintf("You paid %v gold for new units.", cost)
+	}
+	if len(refused) > 0 {
+		tu.Refused = refused
+	}
+	return tu, cost > 0 || len(refused) > 0 || !ok
+}
+
+// reconcile must be called with the lock held. It keeps the server's version
+// of every unit it already knows: HP and XP only change through wars and
+// attrition the server applied, locations only through moves it relayed.
+// Only a known unit's rank is taken from next, changing it is paid for like
+// a new unit. New units start out unhurt and untrained, and units the server
+// removed stay gone.
+func (w *World) reconcile(prev, next Player) Player {
+	units := map[int]Unit{}
+	for id, unit := range next.Units {
+		if _, ok := w.dead[next.Username][id]; ok {
+			continue
+		}
+		if old, ok := prev.Units[id]; ok {
+			old.Rank = unit.Rank
+			units[id] = old
+			continue
+		}
+		units[id] = Unit{ID: id, Rank: unit.Rank, Location: unit.Location}
+	}
+	next.Units = units
+	return next
+}
+
+// bury must be called with the lock held. It remembers units the server
+// removed from a player.
+func (w *World) bury(username string, ids ...int) {
+	if _, ok := w.dead[username]; !ok {
+		w.dead[username] = map[int]struct{}{}
+	}
+	for _, id := range ids {
+		w.dead[username][id] = struct{}{}
+	}
+}
+
+// nextUnitID must be called with the lock held. It is the lowest ID above
+// every unit of the player the server knows, alive or dead.
+func (w *World) nextUnitID(username string) int {
+	next := 1
+	for id := range w.players[username].Units {
+		next = max(next, id+1)
+	}
+	for id := range w.dead[username] {
+		next = max(next, id+1)
+	}
+	return next
+}
+
+// EndTurn pays every player for the locations it holds.
+func (w *World) EndTurn() []TreasuryUpdate {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advanceControlStreaks()
+	updates := []TreasuryUpdate{}
+	for _, username := range w.usernames() {
+		income := incomeFor(w.players[username])
+		w.treasuries[username] += income
+		updates = append(updates, TreasuryUpdate{
+			Username: username,
+			Balance:  w.treasuries[username],
+			Income:   income,
+		})
+	}
+	return updates
+}
+
+// Resupply traces every player's supply back to its home base and works
+// out the attrition of its overstacked units at the end of a turn. The
+// locations it finds cut off are what wars are fought with until the next
+// turn.
+func (w *World) Resupply() []SupplyUpdate {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	updates := []SupplyUpdate{}
+	for _, username := range w.usernames() {
+		su := supplyFor(w.players[username], w.homes[username], w.friendlyHeld(username))
+		w.cutOff[username] = su.OutOfSupply
+		w.applyAttrition(username, su.Attrition)
+		updates = append(updates, su)
+	}
+	return updates
+}
+
+// applyAttrition must be called with the lock held. The server applies the
+// attrition it works out right away, so control does not wait for the
+// player to report its losses.
+func (w *World) applyAttrition(username string, attrition map[int]int) {
+	if len(attrition) == 0 {
+		return
+	}
+	p := w.players[username]
+	units := map[int]Unit{}
+	for id, unit := range p.Units {
+		if damage, ok := attrition[id]; ok {
+			unit.HP = unit.health() - damage
+			if unit.HP <= 0 {
+				w.bury(username, id)
+				continue
+			}
+		}
+		units[id] = unit
+	}
+	p.Units = units
+	w.players[username] = p
+}
+
+// friendlyHeld must be called with the lock held. It is every location the
+// player or its teammates control or have units in.
+func (w *World) friendlyHeld(username string) []Location {
+	friendly := []string{username}
+	if team := w.teams[username]; team != "" {
+		friendly = w.members(team)
+	}
+	locations := []Location{}
+	for _, username := range friendly {
+		locations = append(locations, w.controlledBy(username)...)
+		locations = append(locations, holdings(w.players[username])...)
+	}
+	return locations
+}
+
+func (w *World) Usernames() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.usernames()
+}
+
+// usernames must be called with the lock held.
+func (w *World) usernames() []string {
+	usernames := []string{}
+	for username := range w.players {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
@@ -0,0 +1,267 @@
+package gamelogic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+)
+
+type MapMode string
+
+const (
+	MapModeColor = "color"
+	MapModePlain = "plain"
+	MapModeList  = "list"
+	MapModeJSON  = "json"
+)
+
+// MapCell is everything the player knows about one location: who controls
+// it, its own units there and the units of its allies and enemies last seen
+// there.
+type MapCell struct {
+	Location  Location
+	Owner     string
+	Contested bool
+	Units     int
+	Allies    map[string]int
+	Enemies   map[string]int
+}
+
+const (
+	mapCellWidth = 16
+	mapGapWidth  = 6
+)
+
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+)
+
+// mapLayout places the continents roughly where they are on a globe.
+// Americas and asia, antarctica and australia meet across its edges.
+var mapLayout = [2][3]Location{
+	{"americas", "europe", "asia"},
+	{"antarctica", "africa", "australia"},
+}
+
+// CommandMap draws the world as the player knows it. Colors are only used
+// when the output is a terminal that supports them, plain, list and json are
+// for scripts.
+func (gs *GameState) CommandMap(words []string) error {
+	mode := MapMode(MapModePlain)
+	if colorSupported() {
+		mode = MapModeColor
+	}
+	if len(words) > 1 {
+		mode = MapMode(words[1])
+		if mode != MapModePlain && mode != MapModeList && mode != MapModeJSON {
+			return errors.New("usage: map [plain|list|json]")
+		}
+	}
+	if mode == MapModeList {
+		gs.printTerritories()
+		return nil
+	}
+
+	cells := gs.mapCells()
+	if mode == MapModeJSON {
+		data, err := json.MarshalIndent(cells, "", "  ")
+		if err != nil {
+			return fmt.Errorf("could not encode map: %v", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Print(renderMap(cells, gs.GetUsername(), mode == MapModeColor))
+	return nil
+}
+
+// mapCells puts together the territories, the player's units and its intel
+// for every location, sorted by location. Players it is allied with are
+// not counted as enemies.
+func (gs *GameState) mapCells() []MapCell {
+	p := gs.GetPlayerSnap()
+	intel := gs.getIntelSnap()
+	cells := []MapCell{}
+	for _, t := range gs.getTerritoriesSnap() {
+		cell := MapCell{
+			Location:  t.Location,
+			Owner:     t.Owner,
+			Contested: t.Contested,
+			Units:     len(unitsInLocation(p, t.Location)),
+			Allies:    map[string]int{},
+			Enemies:   map[string]int{},
+		}
+		for username, sightings := range intel {
+			units := len(sightings[t.Location].Units)
+			if units == 0 {
+				continue
+			}
+			if gs.isAlly(username) {
+				cell.Allies[username] = units
+			} else {
+				cell.Enemies[username] = units
+			}
+		}
+		cells = append(cells, cell)
+	}
+	return cells
+}
+
+// renderMap draws the cells as boxes laid out like mapLayout, joined where
+// the locations are adjacent.
+func renderMap(cells []MapCell, username string, color bool) string {
+	byLocation := map[Location]MapCell{}
+	for _, cell := range cells {
+		byLocation[cell.Location] = cell
+	}
+	adjacent := getAdjacentLocations()
+
+	var sb strings.Builder
+	for row, locations := range mapLayout {
+		boxes := [3][]string{}
+		for col, loc := range locations {
+			boxes[col] = renderMapCell(byLocation[loc], username, color)
+		}
+		for line := range boxes[0] {
+			for col := range locations {
+				if col > 0 {
+					gap := strings.Repeat(" ", mapGapWidth)
+					// the name line carries the link to the box on the left
+					if line == 1 && slices.Contains(adjacent[locations[col-1]], locations[col]) {
+						gap = strings.Repeat("-", mapGapWidth)
+					}
+					sb.WriteString(gap)
+				}
+				sb.WriteString(boxes[col][line])
+			}
+			sb.WriteString("\n")
+		}
+		if row == 0 {
+			sb.WriteString(renderMapLinks())
+		}
+	}
+	sb.WriteString("Americas and asia, antarctica and australia meet across the edges of the map.\n")
+	return sb.String()
+}
+
+// renderMapLinks draws the links between the two rows of the map.
+func renderMapLinks() string {
+	boxWidth := mapCellWidth + 2
+	width := 3*boxWidth + 2*mapGapWidth
+	lines := [2][]byte{[]byte(strings.Repeat(" ", width)), []byte(strings.Repeat(" ", width))}
+	adjacent := getAdjacentLocations()
+	for col := range mapLayout[0] {
+		center := col*(boxWidth+mapGapWidth) + boxWidth/2
+		for _, loc := range adjacent[mapLayout[0][col]] {
+			switch {
+			case loc == mapLayout[1][col]:
+				lines[0][center] = '|'
+				lines[1][center] = '|'
+			case col+1 < 3 && loc == mapLayout[1][col+1]:
+				gap := col*(boxWidth+mapGapWidth) + boxWidth
+				lines[0][gap+1] = '\\'
+				lines[1][gap+mapGapWidth-2] = '\\'
+			case col > 0 && loc == mapLayout[1][col-1]:
+				gap := col*(boxWidth+mapGapWidth) - mapGapWidth
+				lines[0][gap+mapGapWidth-2] = '/'
+				lines[1][gap+1] = '/'
+			}
+		}
+	}
+	return strings.TrimRight(string(lines[0]), " ") + "\n" + strings.TrimRight(string(lines[1]), " ") + "\n"
+}
+
+// renderMapCell draws one location as the lines of a box. Text is padded
+// before it is colored so escape codes never break the alignment.
+func renderMapCell(cell MapCell, username string, color bool) []string {
+	paint := func(text, code string) string {
+		if !color || code == "" {
+			return text
+		}
+		return code + text + ansiReset
+	}
+
+	status, statusColor := "unclaimed", ""
+	switch {
+	case cell.Contested && cell.Owner != "":
+		status, statusColor = "contested: "+ownerName(cell.Owner, username), ansiYellow
+	case cell.Contested:
+		status, statusColor = "contested", ansiYellow
+	case cell.Owner == username:
+		status, statusColor = "held by you", ansiGreen
+	case cell.Owner != "":
+		status, statusColor = "held by "+cell.Owner, ansiRed
+	}
+
+	units := ""
+	if cell.Units > 0 {
+		units = fmt.Sprintf("your units: %v", cell.Units)
+	}
+	allies := countMapUnits("allies", cell.Allies)
+	enemies := countMapUnits("enemies", cell.Enemies)
+
+	border := "+" + strings.Repeat("-", mapCellWidth) + "+"
+	line := func(text, code string, center bool) string {
+		return "|" + paint(fitMapText(text, center), code) + "|"
+	}
+	return []string{
+		border,
+		line(strings.ToUpper(string(cell.Location)), ansiBold, true),
+		line(status, statusColor, false),
+		line(units, ansiGreen, false),
+		line(allies, ansiGreen, false),
+		line(enemies, ansiRed, false),
+		border,
+	}
+}
+
+// countMapUnits sums the units of several players under label, or names the
+// player if there is only one.
+func countMapUnits(label string, units map[string]int) string {
+	if len(units) == 0 {
+		return ""
+	}
+	names := []string{}
+	total := 0
+	for name, count := range units {
+		names = append(names, name)
+		total += count
+	}
+	sort.Strings(names)
+	if len(names) == 1 {
+		return fmt.Sprintf("%s: %v", names[0], total)
+	}
+	return fmt.Sprintf("%s: %v", label, total)
+}
+
+// fitMapText pads or cuts text to the width of a map cell.
+func fitMapText(text string, center bool) string {
+	if len(text) > mapCellWidth-2 {
+		text = text[:mapCellWidth-2]
+	}
+	left := 1
+	if center {
+		left = (mapCellWidth - len(text)) / 2
+	}
+	return strings.Repeat(" ", left) + text + strings.Repeat(" ", mapCellWidth-left-len(text))
+}
+
+// colorSupported reports whether stdout is a terminal that wants colors.
+func colorSupported() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,67 @@
+package gamelogic
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMapCellsTellAlliesFromEnemies(t *testing.T) {
+	gs := NewGameState("alice")
+	gs.addUnit(Unit{ID: 1, Rank: RankInfantry, Location: "europe"})
+	gs.setTreaty(Treaty{Kind: TreatyAlliance, With: "bob"})
+	gs.recordSighting(Player{Username: "bob", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+	}}, "europe")
+	gs.recordSighting(Player{Username: "carol", Units: map[int]Unit{
+		1: {ID: 1, Rank: RankInfantry, Location: "europe"},
+		2: {ID: 2, Rank: RankCavalry, Location: "europe"},
+	}}, "europe")
+	gs.setTerritory(Territory{Location: "europe", Owner: "alice", Contested: true})
+
+	var europe MapCell
+	for _, cell := range gs.mapCells() {
+		if cell.Location == "europe" {
+			europe = cell
+		}
+	}
+	want := MapCell{
+		Location:  "europe",
+		Owner:     "alice",
+		Contested: true,
+		Units:     1,
+		Allies:    map[string]int{"bob": 1},
+		Enemies:   map[string]int{"carol": 2},
+	}
+	if !reflect.DeepEqual(europe, want) {
+		t.Errorf("expected %+v, got %+v", want, europe)
+	}
+}
+
+func TestRenderMap(t *testing.T) {
+	cells := []MapCell{
+		{Location: "europe", Owner: "alice", Units: 2, Allies: map[string]int{}, Enemies: map[string]int{"carol": 1, "dave": 2}},
+		{Location: "asia", Owner: "bob", Allies: map[string]int{"bob": 3}, Enemies: map[string]int{}},
+		{Location: "africa", Contested: true, Allies: map[string]int{}, Enemies: map[string]int{}},
+	}
+	out := renderMap(cells, "alice", false)
+
+	for _, want := range []string{"EUROPE", "held by you", "your units: 2", "enemies: 3", "held by bob", "bob: 3", "contested", "unclaimed"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected the map to contain %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "\033[") {
+		t.Error("expected no colors in a plain map")
+	}
+	// every line of a row of boxes lines up
+	lines := strings.Split(out, "\n")
+	if len(lines[0]) != len(lines[1]) {
+		t.Errorf("expected box lines of equal width, got %q and %q", lines[0], lines[1])
+	}
+
+	colored := renderMap(cells, "alice", true)
+	if !strings.Contains(colored, ansiGreen+" held by you") {
+		t.Error("expected the player's own location to be green")
+	}
+}
@@ -57,7 +57,11 @@ func DeclareAndBindQueue(
 		return nil, amqp.Queue{}, fmt.Errorf("failed to declare queue: %v", err)
 	}
 
-	err = channel.QueueBind(queueName,key,exchange,false,nil)
+	// the default exchange already routes to every queue by its name
+	if exchange == routing.ExchangeDefault{
+		return channel, queue, nil
+	}
+	err = channel.QueueBind(queue.Name,key,exchange,false,nil)
 	if err != nil{
 		return nil, amqp.Queue{}, fmt.Errorf("failed to bind queue: %v", err)
 	}
@@ -65,6 +69,21 @@ func DeclareAndBindQueue(
 	return channel, queue, nil
 }
 
+// BindQueue binds an already declared queue to another key, so a single subscription receives both.
+func BindQueue(conn *amqp.Connection, exchange, queueName, key string) error{
+	channel, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to open channel: %v", err)
+	}
+	defer channel.Close()
+
+	err = channel.QueueBind(queueName,key,exchange,false,nil)
+	if err != nil{
+		return fmt.Errorf("failed to bind queue: %v", err)
+	}
+	return nil
+}
+
 // SubscribeJSON sets up a subscription to a queue and processes incoming messages using the provided handler function.
 // Declares and binds the queue if it does not already exist.
 // Accepts any struct type T for message deserialization.
@@ -76,9 +95,24 @@ func SubscribeJSON[T any](
     queueType SimpleQueueType, // an enum to represent "durable" or "transient"
     handler func(T)(AnkType),
 )  error {
+	_, err := subscribe(conn, exchange, queueName, key, queueType, nil, handler)
+	return err
+}
+
+// subscribe is shared by SubscribeJSON, SubscribeVerifiedJSON and SubscribeInboxJSON. It returns the name of
+// the queue it consumes. Messages that fail verify are discarded, which dead-letters them.
+func subscribe[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	verify func(amqp.Delivery, T) error,
+	handler func(T)(AnkType),
+) (string, error) {
 	channel, queue, err := DeclareAndBindQueue(conn, exchange, queueName, key, queueType)
 	if err != nil {
-		return fmt.Errorf("failed to declare and bind queue: %v", err)
+		return "", fmt.Errorf("failed to declare and bind queue: %v", err)
 	}
 
 	msgs, err := channel.Consume(
@@ -91,7 +125,7 @@ func SubscribeJSON[T any](
 		nil,
 	)
 	if err != nil {
-		return  fmt.Errorf("failed to receive messages: %v", err) 
+		return "", fmt.Errorf("failed to receive messages: %v", err) 
 	}
 
 	go func(){
@@ -104,6 +138,14 @@ func SubscribeJSON[T any](
 				msg.Nack(false, false)
 				continue
 			}
+			if verify != nil{
+				err = verify(msg, genericMsgStruct)
+				if err != nil{
+					log.Printf("Message failed verification, message dead-lettered: %v\n", err)
+					msg.Nack(false, false)
+					continue
+				}
+			}
 			ank := handler(genericMsgStruct)
 			switch ank{
 				case Ack:
@@ -119,5 +161,5 @@ func SubscribeJSON[T any](
 		}
 	}()
 
-	return nil
+	return queue.Name, nil
 }
\ No newline at end of file
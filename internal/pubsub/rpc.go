@@ -0,0 +1,177 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// directReplyTo is RabbitMQ's pseudo queue for replies, it needs no declaring and no cleaning up.
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// errorHeader carries the error a Serve handler returned instead of a response.
+const errorHeader = "x-peril-error"
+
+var (
+	// ErrTimeout is returned by Request when the context ends before a reply arrives.
+	ErrTimeout = errors.New("request timed out")
+	// ErrNoServer is returned by Request when no queue is bound to the request's key.
+	ErrNoServer = errors.New("no server is listening for the request")
+	// ErrClosed is returned by Request when the channel closes while waiting.
+	ErrClosed = errors.New("channel closed before a reply arrived")
+)
+
+// RemoteError is an error returned by the Serve handler on the other side.
+type RemoteError struct {
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// Request publishes req and waits for the reply of whoever Serves key, until ctx is done.
+// Errors are ErrTimeout, ErrNoServer, ErrClosed or a *RemoteError, possibly wrapped.
+func Request[Req, Resp any](ctx context.Context, conn *amqp.Connection, exchange, key string, req Req) (Resp, error){
+	var resp Resp
+
+	channel, err := conn.Channel()
+	if err != nil{
+		return resp, fmt.Errorf("failed to open channel: %v", err)
+	}
+	defer channel.Close()
+
+	// Replies only reach a consumer that exists before the request is published, on the same channel.
+	replies, err := channel.Consume(directReplyTo, "", true, false, false, false, nil)
+	if err != nil{
+		return resp, fmt.Errorf("failed to consume replies: %v", err)
+	}
+	returns := channel.NotifyReturn(make(chan amqp.Return, 1))
+
+	marshaledReq, err := json.Marshal(req)
+	if err != nil{
+		return resp, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	correlationID, err := newCorrelationID()
+	if err != nil{
+		return resp, err
+	}
+	msg := amqp.Publishing{
+		ContentType:   "application/json",
+		CorrelationId: correlationID,
+		ReplyTo:       directReplyTo,
+		Body:          marshaledReq,
+	}
+	// mandatory, so a request nobody serves comes back instead of vanishing
+	err = channel.PublishWithContext(ctx, exchange, key, true, false, msg)
+	if err != nil{
+		return resp, fmt.Errorf("failed to publish request: %v", err)
+	}
+
+	for {
+		select{
+			case <-ctx.Done():
+				return resp, fmt.Errorf("%w: %v", ErrTimeout, ctx.Err())
+			case ret, ok := <-returns:
+				if !ok{
+					return resp, ErrClosed
+				}
+				// only this request coming back means nobody serves key
+				if ret.CorrelationId != correlationID{
+					continue
+				}
+				return resp, fmt.Errorf("%w: %s", ErrNoServer, key)
+			case reply, ok := <-replies:
+				if !ok{
+					return resp, ErrClosed
+				}
+				if reply.CorrelationId != correlationID{
+					continue
+				}
+				if remoteErr, ok := reply.Headers[errorHeader].(string); ok{
+					return resp, &RemoteError{Message: remoteErr}
+				}
+				err = json.Unmarshal(reply.Body, &resp)
+				if err != nil{
+					return resp, fmt.Errorf("failed to unmarshal reply: %v", err)
+				}
+				return resp, nil
+		}
+	}
+}
+
+// Serve answers every Request published to key with the handler's response, or with its error.
+// Declares and binds the queue if it does not already exist.
+func Serve[Req, Resp any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	handler func(Req)(Resp, error),
+) error {
+	channel, queue, err := DeclareAndBindQueue(conn, exchange, queueName, key, queueType)
+	if err != nil {
+		return fmt.Errorf("failed to declare and bind queue: %v", err)
+	}
+
+	msgs, err := channel.Consume(queue.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return fmt.Errorf("failed to receive messages: %v", err)
+	}
+
+	go func(){
+		for msg := range msgs{
+			if msg.ReplyTo == ""{
+				log.Println("Request without a reply address, message discarded.")
+				msg.Nack(false, false)
+				continue
+			}
+			var req Req
+			err := json.Unmarshal(msg.Body, &req)
+			if err != nil{
+				log.Printf("Failed to unmarshal request: %v\n", err)
+				msg.Nack(false, false)
+				continue
+			}
+
+			reply := amqp.Publishing{
+				ContentType:   "application/json",
+				CorrelationId: msg.CorrelationId,
+			}
+			resp, err := handler(req)
+			if err != nil{
+				reply.Headers = amqp.Table{errorHeader: err.Error()}
+			} else {
+				reply.Body, err = json.Marshal(resp)
+				if err != nil{
+					reply.Headers = amqp.Table{errorHeader: fmt.Sprintf("failed to marshal response: %v", err)}
+				}
+			}
+
+			// replies go through the default exchange straight to the requester's reply queue
+			err = channel.PublishWithContext(context.Background(), "", msg.ReplyTo, false, false, reply)
+			if err != nil{
+				log.Printf("Failed to publish reply: %v\n", err)
+			}
+			msg.Ack(false)
+		}
+	}()
+
+	return nil
+}
+
+func newCorrelationID() (string, error){
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil{
+		return "", fmt.Errorf("failed to generate correlation id: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
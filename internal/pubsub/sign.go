@@ -0,0 +1,162 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/bootdotdev/learn-pub-sub-starter/internal/routing"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+const (
+	signerHeader    = "x-peril-signer"
+	signatureHeader = "x-peril-signature"
+)
+
+// KeyRing maps usernames to the Ed25519 public keys their messages are signed with.
+// Signatures are required unless the ring is told otherwise, messages that are signed
+// are always verified.
+type KeyRing struct {
+	keys     map[string]ed25519.PublicKey
+	required bool
+	mu       *sync.RWMutex
+}
+
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys:     map[string]ed25519.PublicKey{},
+		required: true,
+		mu:       &sync.RWMutex{},
+	}
+}
+
+// SetRequired decides whether unsigned messages are dead-lettered or let through.
+func (kr *KeyRing) SetRequired(required bool) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.required = required
+}
+
+func (kr *KeyRing) Required() bool {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.required
+}
+
+// Replace swaps every key in the ring for the ones given.
+func (kr *KeyRing) Replace(keys map[string][]byte) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys = map[string]ed25519.PublicKey{}
+	for username, key := range keys {
+		if len(key) == ed25519.PublicKeySize {
+			kr.keys[username] = ed25519.PublicKey(key)
+		}
+	}
+}
+
+func (kr *KeyRing) PublicKey(username string) (ed25519.PublicKey, bool) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ok := kr.keys[username]
+	return key, ok
+}
+
+// PublishSignedJSON works like PublishJSON, but signs the body with the signer's private key. The exchange and
+// key are signed too, so the message can not be re-published anywhere else.
+func PublishSignedJSON[T any](ch *amqp.Channel, exchange, key string, val T, signer string, privateKey ed25519.PrivateKey) error{
+	marshaledVal, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Errorf("failed to marshal value: %v", err)
+	}
+
+	msg := amqp.Publishing{
+		ContentType: "application/json",
+		Body: marshaledVal,
+		Headers: signatureHeaders(exchange, key, marshaledVal, signer, privateKey),
+	}
+
+	err = ch.PublishWithContext(context.Background(), exchange, key, false, false, msg)
+	if err != nil {
+		return fmt.Errorf("failed to publish message: %v", err)
+	}
+
+	return nil
+}
+
+// SubscribeVerifiedJSON works like SubscribeJSON, but only hands the handler messages signed by the
+// username claimed returns for them. Anything else is dead-lettered.
+func SubscribeVerifiedJSON[T any](
+	conn *amqp.Connection,
+	exchange,
+	queueName,
+	key string,
+	queueType SimpleQueueType,
+	keys *KeyRing,
+	claimed func(T) string,
+	handler func(T)(AnkType),
+) error {
+	verify := func(msg amqp.Delivery, val T) error{
+		return verifySignature(msg, keys, claimed(val))
+	}
+	_, err := subscribe(conn, exchange, queueName, key, queueType, verify, handler)
+	return err
+}
+
+// SubscribeInboxJSON works like SubscribeVerifiedJSON on a private queue named by the broker. Only this
+// connection reads from it and nothing can be bound to it, messages reach it on the default exchange from
+// whoever is handed the returned name.
+func SubscribeInboxJSON[T any](
+	conn *amqp.Connection,
+	keys *KeyRing,
+	claimed func(T) string,
+	handler func(T)(AnkType),
+) (string, error) {
+	verify := func(msg amqp.Delivery, val T) error{
+		return verifySignature(msg, keys, claimed(val))
+	}
+	return subscribe(conn, routing.ExchangeDefault, "", "", QueueTypeTransient, verify, handler)
+}
+
+func signatureHeaders(exchange, key string, body []byte, signer string, privateKey ed25519.PrivateKey) amqp.Table{
+	return amqp.Table{
+		signerHeader:    signer,
+		signatureHeader: ed25519.Sign(privateKey, signedContent(exchange, key, body)),
+	}
+}
+
+// signedContent is what a signature covers: where the message was published to and its body.
+func signedContent(exchange, key string, body []byte) []byte{
+	content := []byte(exchange + "\x00" + key + "\x00")
+	return append(content, body...)
+}
+
+func verifySignature(msg amqp.Delivery, keys *KeyRing, claimed string) error{
+	_, signed := msg.Headers[signerHeader]
+	if !signed && !keys.Required() {
+		return nil
+	}
+	signer, ok := msg.Headers[signerHeader].(string)
+	if !ok {
+		return errors.New("message is not signed")
+	}
+	if signer != claimed {
+		return fmt.Errorf("message signed by %s claims to be from %s", signer, claimed)
+	}
+	signature, ok := msg.Headers[signatureHeader].([]byte)
+	if !ok {
+		return errors.New("message has no signature")
+	}
+	publicKey, ok := keys.PublicKey(signer)
+	if !ok {
+		return fmt.Errorf("no public key registered for %s", signer)
+	}
+	if !ed25519.Verify(publicKey, signedContent(msg.Exchange, msg.RoutingKey, msg.Body), signature) {
+		return fmt.Errorf("invalid signature from %s", signer)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestVerifySignature(t *testing.T) {
+	alicePublic, alicePrivate, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, malloryPrivate, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte(`{"Username":"alice"}`)
+
+	cases := []struct {
+		name     string
+		msg      amqp.Delivery
+		claimed  string
+		required bool
+		ok       bool
+	}{
+		{"signed by the claimed player", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r1.diplomacy.bob", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", alicePrivate)}, "alice", true, true},
+		{"claiming to be someone else", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r1.diplomacy.bob", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", alicePrivate)}, "bob", true, false},
+		{"signed with someone else's key", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r1.diplomacy.bob", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", malloryPrivate)}, "alice", true, false},
+		{"changed after signing", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r1.diplomacy.bob", Body: []byte(`{"Username":"bob"}`), Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", alicePrivate)}, "alice", true, false},
+		{"re-published under another key", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r2.diplomacy.carol", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", alicePrivate)}, "alice", true, false},
+		{"re-published on another exchange", amqp.Delivery{Exchange: "peril_direct", RoutingKey: "r1.diplomacy.bob", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", alicePrivate)}, "alice", true, false},
+		{"signed by an unknown player", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r1.diplomacy.bob", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "carol", alicePrivate)}, "carol", true, false},
+		{"unsigned", amqp.Delivery{Body: body}, "alice", true, false},
+		{"unsigned while signing is optional", amqp.Delivery{Body: body}, "alice", false, true},
+		{"badly signed while signing is optional", amqp.Delivery{Exchange: "peril_topic", RoutingKey: "r1.diplomacy.bob", Body: body, Headers: signatureHeaders("peril_topic", "r1.diplomacy.bob", body, "alice", malloryPrivate)}, "alice", false, false},
+	}
+	for _, c := range cases {
+		keys := NewKeyRing()
+		keys.Replace(map[string][]byte{"alice": alicePublic})
+		keys.SetRequired(c.required)
+
+		err := verifySignature(c.msg, keys, c.claimed)
+		if c.ok && err != nil {
+			t.Errorf("%s: expected the message to verify, got %v", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected the message to fail verification", c.name)
+		}
+	}
+}
@@ -2,8 +2,17 @@ package routing
 
 import "time"
 
+// PlayingState pauses or resumes a room or a single player. A pause with an
+// Expiry ends on its own once the expiry has passed.
 type PlayingState struct {
 	IsPaused bool
+	Reason   string
+	Expiry   time.Time
+}
+
+// Expired reports whether a timed pause has run out.
+func (ps PlayingState) Expired() bool {
+	return ps.IsPaused && !ps.Expiry.IsZero() && time.Now().After(ps.Expiry)
 }
 
 type GameLog struct {
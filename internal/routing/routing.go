@@ -1,17 +1,97 @@
 package routing
 
+import (
+	"fmt"
+	"strings"
+)
+
 const (
 	ArmyMovesPrefix = "army_moves"
 
 	WarRecognitionsPrefix = "war"
 
+	WarResolutionsPrefix = "war_resolutions"
+
+	WarAcksPrefix = "war_acks"
+
+	WarDecisionsPrefix = "war_decisions"
+
 	PauseKey = "pause"
 
+	PlayerStatesKey = "player_states"
+
+	TreasuryPrefix = "treasury"
+
+	SupplyPrefix = "supply"
+
+	ControlKey = "control"
+
+	GameOverKey = "game_over"
+
+	LobbyKey = "lobby"
+
+	HeartbeatsKey = "heartbeats"
+
+	PresencePrefix = "presence"
+
+	AuthKey = "auth"
+
+	KeysKey = "keys"
+
+	AdminPrefix = "admin"
+
+	AnnouncementsKey = "announcements"
+
+	ResetKey = "reset"
+
+	SyncKey = "sync"
+
+	BattlefieldKey = "battlefield"
+
+	TeamsKey = "teams"
+
+	ChatPrefix = "chat"
+
+	ChatLogsKey = "chat_logs"
+
+	DiplomacyPrefix = "diplomacy"
+
 	GameLogSlug = "game_logs"
+
+	DefaultRoom = "default"
 )
 
 const (
 	ExchangePerilDirect = "peril_direct"
 	ExchangePerilTopic  = "peril_topic"
 	ExchangePerilDLX = "peril_dlx"
+	// ExchangeDefault routes a message straight to the queue its key names. Nobody can bind a queue to it,
+	// so only the queue's consumer sees what is sent there.
+	ExchangeDefault = ""
 )
+
+// RoomKey scopes a routing key or queue name to a game room, e.g.
+// RoomKey("r1", ArmyMovesPrefix, "bob") is "r1.army_moves.bob".
+func RoomKey(room string, parts ...string) string {
+	return strings.Join(append([]string{room}, parts...), ".")
+}
+
+// ValidateRoom makes sure a room name is a single routing key word.
+func ValidateRoom(room string) error {
+	if !isWord(room) {
+		return fmt.Errorf("error: %q is not a valid room name", room)
+	}
+	return nil
+}
+
+// ValidateName makes sure a name used in routing keys, like a team's, is a single routing key word.
+func ValidateName(name string) error {
+	if !isWord(name) {
+		return fmt.Errorf("error: %q is not a valid name", name)
+	}
+	return nil
+}
+
+func isWord(s string) bool {
+	return s != "" && !strings.ContainsAny(s, ".*#")
+}